@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// queueDepthHeader vai em toda resposta de POST /payments para que o cliente e o LB
+// enxerguem a pressão sobre a fila.
+const queueDepthHeader = "X-Queue-Depth"
+
+var (
+	admissionWait       = getenvDurationMS("ADMISSION_WAIT_MS", 5)
+	admissionShedRatio  = float64(getenvInt("ADMISSION_SHED_PERCENT", 95)) / 100
+	admissionRetryAfter = getenvInt("ADMISSION_RETRY_AFTER_SEC", 1)
+
+	admissionStats AdmissionStats
+)
+
+type AdmissionStats struct {
+	Accepted atomic.Int64
+	Shed     atomic.Int64
+	TimedOut atomic.Int64
+}
+
+// admit tenta colocar p na fila sem bloquear o handler indefinidamente: acima do limite de
+// ocupação o pagamento é recusado na hora; abaixo dele espera no máximo admissionWait.
+func admit(p *PaymentRequest) bool {
+	if float64(len(queue)) >= admissionShedRatio*float64(cap(queue)) {
+		admissionStats.Shed.Add(1)
+		return false
+	}
+
+	select {
+	case queue <- p:
+		admissionStats.Accepted.Add(1)
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(admissionWait)
+	defer timer.Stop()
+	select {
+	case queue <- p:
+		admissionStats.Accepted.Add(1)
+		return true
+	case <-timer.C:
+		admissionStats.TimedOut.Add(1)
+		return false
+	}
+}
+
+func rejectUnavailable(c *fiber.Ctx, reason string) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(admissionRetryAfter))
+	return c.Status(fiber.StatusServiceUnavailable).SendString(reason)
+}
+
+func admissionStatsHandler(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"queueDepth":     len(queue),
+		"queueCapacity":  cap(queue),
+		"scheduled":      retryScheduler.Pending(),
+		"shedThreshold":  int(admissionShedRatio * float64(cap(queue))),
+		"accepted":       admissionStats.Accepted.Load(),
+		"shed":           admissionStats.Shed.Load(),
+		"timedOut":       admissionStats.TimedOut.Load(),
+		"retryAfterSecs": admissionRetryAfter,
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int32
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+	// breakerRecovered: uma sonda passou, então este nó volta a mandar para o principal
+	// mesmo que o controlador ainda esteja em RouteHold; qualquer falha reabre.
+	breakerRecovered
+)
+
+// breaker complementa o RouteHold do controlador em cada nó: enquanto o circuito está
+// aberto, depois de um cooldown ele deixa passar até BREAKER_HALF_OPEN_PROBES pagamentos
+// reais como sondas para o processador principal, sem depender do health check. Sonda com
+// sucesso fecha o circuito localmente; com falha reabre com o cooldown dobrado.
+var breaker = newCircuitBreaker(
+	getenvInt("BREAKER_HALF_OPEN_PROBES", 1),
+	getenvDurationMS("BREAKER_COOLDOWN_MS", 1000),
+	getenvDurationMS("BREAKER_MAX_COOLDOWN_MS", 30000),
+)
+
+type CircuitBreaker struct {
+	mu             sync.Mutex
+	state          breakerState
+	openUntil      time.Time
+	cooldown       time.Duration
+	baseCooldown   time.Duration
+	maxCooldown    time.Duration
+	maxProbes      int
+	probesInFlight int
+}
+
+func newCircuitBreaker(maxProbes int, baseCooldown, maxCooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		maxProbes:    maxProbes,
+		cooldown:     baseCooldown,
+		baseCooldown: baseCooldown,
+		maxCooldown:  maxCooldown,
+	}
+}
+
+// Route traduz a decisão do controlador para um worker. probe indica que o resultado do
+// envio deve ser informado em ProbeResult.
+func (b *CircuitBreaker) Route(status RouteDecision, now time.Time) (route RouteDecision, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if status != RouteHold {
+		if b.state != breakerClosed {
+			b.state = breakerClosed
+			b.cooldown = b.baseCooldown
+		}
+		return status, false
+	}
+
+	b.advance(now)
+	switch b.state {
+	case breakerRecovered:
+		return RouteDefault, true
+	case breakerHalfOpen:
+		if b.probesInFlight < b.maxProbes {
+			b.probesInFlight++
+			return RouteDefault, true
+		}
+	}
+	return RouteHold, false
+}
+
+func (b *CircuitBreaker) ProbeResult(success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen && b.probesInFlight > 0 {
+		b.probesInFlight--
+	}
+	if b.state != breakerHalfOpen && b.state != breakerRecovered {
+		return
+	}
+
+	if success {
+		b.state = breakerRecovered
+		b.cooldown = b.baseCooldown
+		return
+	}
+	if b.state == breakerHalfOpen {
+		b.cooldown = min(b.cooldown*2, b.maxCooldown)
+	}
+	b.state = breakerOpen
+	b.openUntil = now.Add(b.cooldown)
+	b.probesInFlight = 0
+}
+
+// CancelProbe devolve a vaga de uma sonda que acabou não sendo enviada.
+func (b *CircuitBreaker) CancelProbe() {
+	b.mu.Lock()
+	if b.state == breakerHalfOpen && b.probesInFlight > 0 {
+		b.probesInFlight--
+	}
+	b.mu.Unlock()
+}
+
+// Accepting indica que o circuito foi fechado localmente por uma sonda bem-sucedida.
+func (b *CircuitBreaker) Accepting() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerRecovered
+}
+
+// WantsProbe indica se há vaga para uma sonda e, se não houver, quanto falta para o cooldown acabar.
+func (b *CircuitBreaker) WantsProbe(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(now)
+	switch b.state {
+	case breakerOpen:
+		return false, b.openUntil.Sub(now)
+	case breakerHalfOpen:
+		return b.probesInFlight < b.maxProbes, time.Hour
+	}
+	return false, time.Hour
+}
+
+// advance abre o circuito na primeira vez que o controlador segura os pagamentos e passa
+// para half-open quando o cooldown termina.
+func (b *CircuitBreaker) advance(now time.Time) {
+	if b.state == breakerClosed {
+		b.state = breakerOpen
+		b.openUntil = now.Add(b.cooldown)
+	}
+	if b.state == breakerOpen && !now.Before(b.openUntil) {
+		b.state = breakerHalfOpen
+		b.probesInFlight = 0
+	}
+}
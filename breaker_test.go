@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := newCircuitBreaker(2, time.Second, 3*time.Second)
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	route := func(status RouteDecision, wantRoute RouteDecision, wantProbe bool) {
+		t.Helper()
+		got, probe := b.Route(status, now)
+		if got != wantRoute || probe != wantProbe {
+			t.Fatalf("Route(%d) em %s = %d, %v; want %d, %v",
+				int32(status), now.Format("15:04:05.000"), int32(got), probe, int32(wantRoute), wantProbe)
+		}
+	}
+
+	// Fora do RouteHold o breaker só repassa a decisão do controlador.
+	route(1, 1, false)
+
+	// O primeiro RouteHold abre o circuito; durante o cooldown nada passa.
+	route(RouteHold, RouteHold, false)
+	if ok, wait := b.WantsProbe(now.Add(400 * time.Millisecond)); ok || wait != 600*time.Millisecond {
+		t.Fatalf("WantsProbe no cooldown = %v, %v", ok, wait)
+	}
+
+	// Depois do cooldown passam até BREAKER_HALF_OPEN_PROBES sondas ao mesmo tempo.
+	now = now.Add(time.Second)
+	route(RouteHold, RouteDefault, true)
+	route(RouteHold, RouteDefault, true)
+	route(RouteHold, RouteHold, false)
+	b.CancelProbe()
+	route(RouteHold, RouteDefault, true)
+
+	// Sonda com falha reabre com o cooldown dobrado.
+	b.ProbeResult(false, now)
+	route(RouteHold, RouteHold, false)
+	now = now.Add(time.Second)
+	route(RouteHold, RouteHold, false)
+	now = now.Add(time.Second)
+	route(RouteHold, RouteDefault, true)
+
+	// O cooldown não passa de BREAKER_MAX_COOLDOWN_MS.
+	b.ProbeResult(false, now)
+	now = now.Add(3 * time.Second)
+	route(RouteHold, RouteDefault, true)
+
+	// Sonda com sucesso fecha localmente: tudo vai para o principal, ainda como sonda.
+	b.ProbeResult(true, now)
+	if !b.Accepting() {
+		t.Fatal("breaker não aceitou depois da sonda com sucesso")
+	}
+	route(RouteHold, RouteDefault, true)
+	route(RouteHold, RouteDefault, true)
+
+	// Uma falha depois de recuperado reabre com o cooldown base.
+	b.ProbeResult(false, now)
+	if b.Accepting() {
+		t.Fatal("breaker continuou aceitando depois da falha")
+	}
+	now = now.Add(time.Second)
+	route(RouteHold, RouteDefault, true)
+
+	// O controlador sair do RouteHold fecha o breaker e zera o cooldown.
+	route(RouteDefault, RouteDefault, false)
+	b.ProbeResult(false, now)
+	route(RouteHold, RouteHold, false)
+	now = now.Add(time.Second)
+	route(RouteHold, RouteDefault, true)
+}
@@ -3,9 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
-	"sort"
+	"slices"
 	"sync/atomic"
 	"time"
 )
@@ -17,26 +16,21 @@ type ServiceHealth struct {
 }
 
 type Metric struct {
-	Primary    bool
+	Processor  int
 	DurationMs int64
 	Failed     bool
+	// Probe marca o envio feito pelo breaker em half-open.
+	Probe bool
 }
 
 var (
 	circuitClient = &http.Client{Timeout: 2 * time.Second}
 
-	primaryURL  = "http://payment-processor-default:8080"
-	fallbackURL = "http://payment-processor-fallback:8080"
-
-	masterURL = "http://api-1:8080"
-	slavesURL = []string{"http://api-2:8080", "http://api-3:8080"}
-
-	circuitStatusFlag int32 // 0 = principal, 1 = fallback, 2 = aberto
+	circuitStatusFlag int32 // RouteDecision: índice do processador ou RouteHold
 
+	// metricsChan leva ao controlador o resultado das sondas do breaker, deste nó ou de
+	// outros; as latências em si ficam em clusterLatency.
 	metricsChan = make(chan Metric, 2000)
-
-	primaryHealth  = ServiceHealth{}
-	fallbackHealth = ServiceHealth{}
 )
 
 func checkHealth(baseURL string) ServiceHealth {
@@ -56,20 +50,17 @@ func checkHealth(baseURL string) ServiceHealth {
 	return health
 }
 
-func circuitController() {
-	useFallbackAllowed := getenvBool("USE_FALLBACK", false)
-	maxDefaultLatency := getenvInt64("MAX_DEFAULT_LATENCY", 100)
-	maxFallbackLatency := getenvInt64("MAX_FALLBACK_LATENCY", 100)
-	sampleWindow := getenvInt("SAMPLE_WINDOW", 100)
+// circuitController roda só no líder, até stop ser fechado; term é o termo da eleição que
+// ele ganhou e prefixa o epoch das decisões.
+func circuitController(stop <-chan struct{}, term uint64) {
 	tickInterval := getenvDurationMS("TICK_INTERVAL_MS", 500)
 	healthInterval := getenvDurationMS("HEALTH_INTERVAL_MS", 5000)
-	failoverDelay := getenvDurationSec("PRIMARY_FAILOVER_DELAY_SEC", 15)
 
-	var (
-		primaryTimes          []int64
-		fallbackTimes         []int64
-		fallbackEligibleSince time.Time
-	)
+	// O nome já foi validado em main; cada mandato começa com uma política sem estado.
+	policy, _ := newRoutingPolicy(routingPolicyName, loadRoutingConfig())
+	fmt.Printf("[%s][CIRCUIT-STATUS] Using %s routing policy\n", getUTCNowFormatted(), policy.Name())
+
+	health := make([]ServiceHealth, len(processors))
 
 	ticker := time.NewTicker(tickInterval)
 	defer ticker.Stop()
@@ -77,124 +68,95 @@ func circuitController() {
 	healthTicker := time.NewTicker(healthInterval)
 	defer healthTicker.Stop()
 
-	primaryHealth = checkHealth(primaryURL)
-	fallbackHealth = checkHealth(fallbackURL)
+	for i, p := range processors {
+		health[i] = checkHealth(p.URL)
+	}
+
+	// Um líder recém-eleito anuncia a primeira decisão mesmo que ela coincida com o
+	// circuitStatusFlag local, já que os outros nós podem ter recebido outra do líder anterior.
+	var seq uint32
 
 	for {
 		select {
+		case <-stop:
+			return
+
 		case m := <-metricsChan:
-			if m.Primary {
-				primaryTimes = append(primaryTimes, m.DurationMs)
-				if len(primaryTimes) > sampleWindow {
-					primaryTimes = primaryTimes[1:]
-				}
-			} else {
-				fallbackTimes = append(fallbackTimes, m.DurationMs)
-				if len(fallbackTimes) > sampleWindow {
-					fallbackTimes = fallbackTimes[1:]
-				}
+			// Uma sonda bem-sucedida é evidência mais recente que a janela e o health check:
+			// recomeça a janela a partir dela para que a política possa fechar o circuito já.
+			if m.Probe && !m.Failed {
+				health[m.Processor].Failing = false
+				processors[m.Processor].clusterLatency.Reset()
+				processors[m.Processor].clusterLatency.Record(m.DurationMs, false)
 			}
 
 		case <-healthTicker.C:
-			primaryHealth = checkHealth(primaryURL)
-			primaryTimes = []int64{int64(primaryHealth.MinResponseTime)}
-			fallbackHealth = checkHealth(fallbackURL)
-			fallbackTimes = []int64{int64(fallbackHealth.MinResponseTime)}
+			for i, p := range processors {
+				health[i] = checkHealth(p.URL)
+				p.clusterLatency.Record(int64(health[i].MinResponseTime), health[i].Failing)
+			}
 
 		case <-ticker.C:
 			now := time.Now()
-
-			var p95Primary, p95Fallback int64
-			if len(primaryTimes) > 0 {
-				p95Primary = p95(primaryTimes)
+			snapshots := make([]ProcessorSnapshot, len(processors))
+			for i, p := range processors {
+				snapshots[i] = processorSnapshot(p, health[i], now)
 			}
-			if len(fallbackTimes) > 0 {
-				p95Fallback = p95(fallbackTimes)
+			in := RoutingInput{
+				Now:        now,
+				Processors: snapshots,
+				QueueDepth: len(queue) + retryScheduler.Pending(),
 			}
-
-			status := 0
-
-			if primaryHealth.Failing && fallbackHealth.Failing {
-				fallbackEligibleSince = time.Time{}
-			} else {
-				if p95Primary > 0 && p95Primary > maxDefaultLatency {
-					if useFallbackAllowed && !fallbackHealth.Failing {
-						if fallbackEligibleSince.IsZero() {
-							fallbackEligibleSince = now
-						}
-						if now.Sub(fallbackEligibleSince) >= failoverDelay {
-							status = 1
-						} else {
-							status = 2
-						}
-					} else {
-						status = 2
-						fallbackEligibleSince = time.Time{}
-					}
-				} else if primaryHealth.Failing {
-					if useFallbackAllowed && !fallbackHealth.Failing {
-						if fallbackEligibleSince.IsZero() {
-							fallbackEligibleSince = now
-						}
-						if now.Sub(fallbackEligibleSince) >= failoverDelay {
-							status = 1
-						} else {
-							status = 2
-						}
-					} else {
-						status = 2
-						fallbackEligibleSince = time.Time{}
-					}
-				} else {
-					fallbackEligibleSince = time.Time{}
+			status := policy.Decide(in)
+			fallbacks := policy.Fallbacks(in, status)
+
+			current := currentCircuitState()
+			if seq == 0 || current.Status != status || !slices.Equal(current.Fallbacks, fallbacks) {
+				seq++
+				state := CircuitState{Status: status, Fallbacks: fallbacks, Epoch: circuitEpoch(term, seq), DecidedAt: now}
+				applyCircuitState(state)
+				for _, peer := range peerNodes() {
+					go notifySlave(state, peer)
 				}
-
-				if status == 1 {
-					if p95Fallback > 0 && p95Fallback > maxFallbackLatency {
-						status = 2
-						fallbackEligibleSince = time.Time{}
-					}
-				}
-			}
-
-			if atomic.LoadInt32(&circuitStatusFlag) != int32(status) {
-				go notifySlave(status, slavesURL[0])
-				go notifySlave(status, slavesURL[1])
-				atomic.StoreInt32(&circuitStatusFlag, int32(status))
-				fmt.Printf("[%s][CIRCUIT-STATUS] Switching circuit to %d\n",
-					getUTCNowFormatted(), status)
+				fmt.Printf("[%s][CIRCUIT-STATUS] Switching circuit to %d (%s) at epoch %d\n",
+					getUTCNowFormatted(), status, status, state.Epoch)
 			}
 		}
 	}
 }
 
-func p95(values []int64) int64 {
-	if len(values) == 0 {
-		return 0
-	}
-	cp := make([]int64, len(values))
-	copy(cp, values)
-	sort.Slice(cp, func(i, j int) bool { return cp[i] < cp[j] })
-	rank := int(math.Ceil(0.95*float64(len(cp)))) - 1
-	if rank < 0 {
-		rank = 0
-	}
-	return cp[rank]
+func setCircuitStatus(status RouteDecision) {
+	atomic.StoreInt32(&circuitStatusFlag, int32(status))
+	retryScheduler.CircuitChanged()
 }
 
-func notifySlave(status int, slaveUrl string) {
-	url := fmt.Sprintf("%s/circuit/%d", slaveUrl, status)
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		fmt.Println("Erro ao criar requisição:", err)
-		return
+func processorSnapshot(p *Processor, health ServiceHealth, now time.Time) ProcessorSnapshot {
+	latency := p.clusterLatency.Summary(now)
+	return ProcessorSnapshot{
+		Index:       p.Index,
+		Name:        p.Name,
+		FeeRate:     p.FeeRate,
+		MaxLatency:  p.MaxLatency,
+		Health:      health,
+		P95:         latency.P95,
+		SuccessRate: latency.SuccessRate,
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	resp, err := circuitClient.Do(req)
+// notifySlave entrega uma decisão a um nó; se a entrega falhar, o nó a busca sozinho em
+// reconcileCircuit.
+func notifySlave(state CircuitState, slaveUrl string) {
+	body, _ := json.Marshal(state)
+	status, _, _, err := sendToPeer("POST", slaveUrl, "/internal/circuit/state", nil, body)
 	if err != nil {
 		fmt.Println("Erro ao enviar requisição:", err)
 		return
 	}
-	defer resp.Body.Close()
+	switch status {
+	case http.StatusNoContent:
+	case http.StatusConflict:
+		fmt.Printf("[%s][CIRCUIT-STATUS] %s rejected epoch %d as stale\n", getUTCNowFormatted(), slaveUrl, state.Epoch)
+	default:
+		fmt.Printf("[%s][CIRCUIT-STATUS] %s rejected epoch %d with status %d\n", getUTCNowFormatted(), slaveUrl, state.Epoch, status)
+	}
 }
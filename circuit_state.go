@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CircuitState é uma decisão do controlador como ela circula entre os nós. Epoch cresce a
+// cada decisão e entre líderes: os 32 bits altos são o termo da eleição e os baixos a
+// sequência dentro do termo, então qualquer decisão de um líder novo supera as do anterior.
+type CircuitState struct {
+	Status RouteDecision `json:"status"`
+	// Fallbacks é a ordem em que os workers tentam outros processadores se o envio a Status falhar.
+	Fallbacks []RouteDecision `json:"fallbacks,omitempty"`
+	Epoch     uint64          `json:"epoch"`
+	DecidedAt time.Time       `json:"decidedAt"`
+}
+
+func circuitEpoch(term uint64, seq uint32) uint64 {
+	return term<<32 | uint64(seq)
+}
+
+// validate recusa rotas que não existem no registro deste nó, como as de um nó com outra
+// lista em PROCESSORS; adotá-las faria todo pagamento daqui falhar.
+func (s CircuitState) validate() error {
+	for _, route := range append([]RouteDecision{s.Status}, s.Fallbacks...) {
+		if route != RouteHold && processorFor(route) == nil {
+			return fmt.Errorf("rota %d fora do registro de %d processadores", route, len(processors))
+		}
+	}
+	return nil
+}
+
+var (
+	circuitStateMu sync.Mutex
+	circuitState   CircuitState
+)
+
+func currentCircuitState() CircuitState {
+	circuitStateMu.Lock()
+	defer circuitStateMu.Unlock()
+	return circuitState
+}
+
+// applyCircuitState adota s se ela for mais nova que a atual e devolve se adotou.
+func applyCircuitState(s CircuitState) bool {
+	circuitStateMu.Lock()
+	if s.Epoch <= circuitState.Epoch {
+		circuitStateMu.Unlock()
+		return false
+	}
+	previous := circuitState
+	circuitState = s
+	circuitStateMu.Unlock()
+
+	if previous.Status != s.Status {
+		setCircuitStatus(s.Status)
+	}
+	return true
+}
+
+// reconcileCircuit busca o estado do líder a cada CIRCUIT_RECONCILE_MS, para que um nó que
+// perdeu notificações convirja mesmo assim.
+func reconcileCircuit() {
+	interval := getenvDurationMS("CIRCUIT_RECONCILE_MS", 2000)
+	for {
+		time.Sleep(interval)
+
+		leader := election.Leader()
+		if leader == "" || leader == nodeURL {
+			continue
+		}
+		status, _, body, err := sendToPeer("GET", leader, "/internal/circuit/state", nil, nil)
+		if err != nil || status != fiber.StatusOK {
+			fmt.Printf("Erro ao buscar estado do circuito em %s: %v\n", leader, err)
+			continue
+		}
+		var s CircuitState
+		if err := json.Unmarshal(body, &s); err != nil {
+			fmt.Printf("Erro ao decodificar estado do circuito: %v\n", err)
+			continue
+		}
+		if err := s.validate(); err != nil {
+			fmt.Printf("Erro no estado do circuito de %s: %v\n", leader, err)
+			continue
+		}
+		if applyCircuitState(s) {
+			fmt.Printf("[%s][CIRCUIT-STATUS] Reconciled circuit to %d (%s) at epoch %d\n",
+				getUTCNowFormatted(), s.Status, s.Status, s.Epoch)
+		}
+	}
+}
+
+func getCircuitStateHandler(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(currentCircuitState())
+}
+
+// updateCircuitStateHandler recebe a notificação do líder; uma atualização velha é
+// recusada com 409 e o estado vigente, e uma rota inválida com 400.
+func updateCircuitStateHandler(c *fiber.Ctx) error {
+	var s CircuitState
+	if err := json.Unmarshal(c.Body(), &s); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if !applyCircuitState(s) {
+		return c.Status(fiber.StatusConflict).JSON(currentCircuitState())
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// nodeURL é o endereço pelo qual os outros nós alcançam esta instância; é obrigatório
+	// para entrar na lista de membros e na eleição.
+	nodeURL    = strings.TrimRight(getenvString("NODE_URL", ""), "/")
+	peerClient = &http.Client{Timeout: getenvDurationMS("PEER_TIMEOUT_MS", 500)}
+)
+
+// clusterNodes são os nós vivos segundo o membership, incluindo este.
+func clusterNodes() []string {
+	return membership.Members()
+}
+
+// peerNodes são os outros nós do cluster.
+func peerNodes() []string {
+	var peers []string
+	for _, node := range clusterNodes() {
+		if node != nodeURL {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
+
+// ownerOf escolhe o nó dono de um correlationId por rendezvous hashing sobre os seeds, então
+// todos os nós concordam sobre o dono sem coordenação e ele não muda quando um nó entra, sai
+// ou reinicia.
+func ownerOf(correlationID string) string {
+	var owner string
+	var best uint64
+	for _, node := range membership.Seeds() {
+		h := fnv.New64a()
+		h.Write([]byte(node))
+		h.Write([]byte{0})
+		h.Write([]byte(correlationID))
+		if score := h.Sum64(); owner == "" || score > best {
+			owner, best = node, score
+		}
+	}
+	return owner
+}
+
+func sendToPeer(method, baseURL, path string, header http.Header, body []byte) (int, http.Header, []byte, error) {
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, baseURL+path, reader)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for k, vv := range header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	if len(body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	signRequest(req, body)
+
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, respBody, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DeadLetter struct {
+	Payment   PaymentRequest `json:"payment"`
+	Node      string         `json:"node"`
+	Attempts  int            `json:"attempts"`
+	LastError string         `json:"lastError"`
+	FailedAt  time.Time      `json:"failedAt"`
+}
+
+var deadLetters = newDeadLetterStore()
+
+type DeadLetterStore struct {
+	mu      sync.Mutex
+	entries map[string]*DeadLetter
+}
+
+func newDeadLetterStore() *DeadLetterStore {
+	return &DeadLetterStore{entries: make(map[string]*DeadLetter)}
+}
+
+func (s *DeadLetterStore) Add(p *PaymentRequest, err error) {
+	s.mu.Lock()
+	s.entries[p.CorrelationID] = &DeadLetter{
+		Payment:   *p,
+		Node:      nodeURL,
+		Attempts:  p.attempts,
+		LastError: err.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+	s.mu.Unlock()
+}
+
+// Restore recoloca uma dead letter salva no último shutdown.
+func (s *DeadLetterStore) Restore(dl DeadLetter) {
+	dl.Payment.attempts = dl.Attempts
+	s.mu.Lock()
+	s.entries[dl.Payment.CorrelationID] = &dl
+	s.mu.Unlock()
+}
+
+func (s *DeadLetterStore) Get(id string) (DeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.entries[id]
+	if !ok {
+		return DeadLetter{}, false
+	}
+	return *dl, true
+}
+
+func (s *DeadLetterStore) List() []DeadLetter {
+	s.mu.Lock()
+	out := make([]DeadLetter, 0, len(s.entries))
+	for _, dl := range s.entries {
+		out = append(out, *dl)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].FailedAt.Before(out[j].FailedAt) })
+	return out
+}
+
+// Replay tira o pagamento da dead-letter queue e o devolve à fila com o orçamento de tentativas zerado.
+func (s *DeadLetterStore) Replay(id string) bool {
+	s.mu.Lock()
+	dl, ok := s.entries[id]
+	if ok {
+		delete(s.entries, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	p := dl.Payment
+	p.attempts = 0
+	tracker.Requeue(id)
+	retryScheduler.Schedule(&p, time.Now())
+	return true
+}
+
+// ReplayAll devolve à fila todas as dead letters deste nó e diz quantas eram.
+func (s *DeadLetterStore) ReplayAll() int {
+	replayed := 0
+	for _, dl := range s.List() {
+		if s.Replay(dl.Payment.CorrelationID) {
+			replayed++
+		}
+	}
+	return replayed
+}
+
+func listDeadLettersHandler(c *fiber.Ctx) error {
+	entries := deadLetters.List()
+	for _, node := range peerNodes() {
+		remote, err := fetchDeadLetters(node)
+		if err != nil {
+			fmt.Printf("Erro ao listar dead letters de %s: %v\n", node, err)
+			continue
+		}
+		entries = append(entries, remote...)
+	}
+	return c.Status(fiber.StatusOK).JSON(entries)
+}
+
+// listLocalDeadLettersHandler é a rota interna usada por listDeadLettersHandler nos outros nós.
+func listLocalDeadLettersHandler(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(deadLetters.List())
+}
+
+func getDeadLetterHandler(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if forwarded, err := forwardToOwner(c, id); forwarded {
+		return err
+	}
+
+	if dl, ok := deadLetters.Get(id); ok {
+		return c.Status(fiber.StatusOK).JSON(dl)
+	}
+	return c.SendStatus(fiber.StatusNotFound)
+}
+
+func replayDeadLetterHandler(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if forwarded, err := forwardToOwner(c, id); forwarded {
+		return err
+	}
+
+	if !deadLetters.Replay(id) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// ReplayReport conta os pagamentos devolvidos à fila por nó; um nó que não respondeu fica em
+// Failed e seus pagamentos continuam na dead-letter queue dele.
+type ReplayReport struct {
+	Replayed int               `json:"replayed"`
+	Nodes    map[string]int    `json:"nodes"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+// replayAllDeadLettersHandler reenvia as dead letters de todos os nós, como
+// listDeadLettersHandler as lista.
+func replayAllDeadLettersHandler(c *fiber.Ctx) error {
+	replayed := deadLetters.ReplayAll()
+	report := ReplayReport{Replayed: replayed, Nodes: map[string]int{nodeURL: replayed}}
+	for _, node := range peerNodes() {
+		remote, err := replayRemoteDeadLetters(node)
+		if err != nil {
+			fmt.Printf("Erro ao reenviar dead letters de %s: %v\n", node, err)
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[node] = err.Error()
+			continue
+		}
+		report.Replayed += remote
+		report.Nodes[node] = remote
+	}
+	return c.Status(fiber.StatusAccepted).JSON(report)
+}
+
+// replayLocalDeadLettersHandler é a rota interna usada por replayAllDeadLettersHandler nos outros nós.
+func replayLocalDeadLettersHandler(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"replayed": deadLetters.ReplayAll()})
+}
+
+func replayRemoteDeadLetters(baseURL string) (int, error) {
+	status, _, body, err := sendToPeer("POST", baseURL, "/internal/dead-letters/replay", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("erro HTTP: status %d", status)
+	}
+
+	var resp struct {
+		Replayed int `json:"replayed"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Replayed, nil
+}
+
+func fetchDeadLetters(baseURL string) ([]DeadLetter, error) {
+	status, _, body, err := sendToPeer("GET", baseURL, "/internal/dead-letters", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("erro HTTP: status %d", status)
+	}
+
+	var entries []DeadLetter
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
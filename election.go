@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// election escolhe entre as instâncias quem roda o circuitController e agrega o resumo.
+// É uma eleição por lease: o candidato pede a cada nó uma concessão por ELECTION_LEASE_MS e
+// só lidera enquanto tiver a maioria. Quem concedeu não concede a outro candidato antes do
+// lease vencer, então não há dois líderes ao mesmo tempo. O líder renova a cada terço do
+// lease; se ele some, os outros esperam o lease vencer e disputam com um termo maior. A
+// maioria é contada sobre membership.Seeds, o mesmo conjunto fixo em todos os nós: duas
+// partições não conseguem ter maioria dele ao mesmo tempo. Nós fora dos seeds podem ser
+// eleitos, mas suas concessões não contam e ninguém lhes pede lease: eles sabem quem lidera
+// pelos heartbeats e pelas respostas de lease, que trazem o líder e quanto falta do lease.
+// MASTER=true só dá preferência ao nó na disputa. Sem NODE_URL a instância não entra no
+// cluster e lidera sozinha; com NODE_URL ela só lidera com a maioria dos seeds, então um nó
+// que não alcança os seeds fica sem controlador em vez de decidir sozinho.
+var election = newLeaderElection(
+	getenvDurationMS("ELECTION_LEASE_MS", 3000),
+	getenvBool("MASTER", false),
+)
+
+type leaseRequest struct {
+	Candidate string `json:"candidate"`
+	Term      uint64 `json:"term"`
+}
+
+type leaseResponse struct {
+	Granted bool   `json:"granted"`
+	Leader  string `json:"leader"`
+	Term    uint64 `json:"term"`
+	// LeaseMs é quanto falta do lease de Leader em quem respondeu.
+	LeaseMs int64 `json:"leaseMs,omitempty"`
+}
+
+type LeaderElection struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	preferred bool
+
+	term        uint64
+	votedFor    string
+	voteExpires time.Time
+
+	// observedLeader é o líder relatado por outro nó, válido até observedExpires.
+	observedLeader  string
+	observedExpires time.Time
+
+	leading      bool
+	leaseExpires time.Time
+	nextRenew    time.Time
+	nextCampaign time.Time
+
+	controllerStop chan struct{}
+}
+
+func newLeaderElection(ttl time.Duration, preferred bool) *LeaderElection {
+	return &LeaderElection{ttl: ttl, preferred: preferred}
+}
+
+// Leading indica se este nó tem um lease válido da maioria.
+func (e *LeaderElection) Leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading && time.Now().Before(e.leaseExpires)
+}
+
+// Leader devolve o nó ao qual este nó concedeu o lease vigente ou, sem concessão, o líder
+// relatado por outro nó; "" se não há líder conhecido.
+func (e *LeaderElection) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if now.Before(e.voteExpires) {
+		return e.votedFor
+	}
+	if now.Before(e.observedExpires) {
+		return e.observedLeader
+	}
+	return ""
+}
+
+// LeaderLease devolve o líder conhecido, o termo e quanto falta do lease, para ser relatado
+// a outros nós.
+func (e *LeaderElection) LeaderLease(now time.Time) (string, uint64, time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now.Before(e.voteExpires) {
+		return e.votedFor, e.term, e.voteExpires.Sub(now)
+	}
+	if now.Before(e.observedExpires) {
+		return e.observedLeader, e.term, e.observedExpires.Sub(now)
+	}
+	return "", e.term, 0
+}
+
+// Observe registra o líder relatado por outro nó. Um líder não aceita relatos: ele só deixa
+// de liderar por um termo maior na renovação.
+func (e *LeaderElection) Observe(leader string, term uint64, remaining time.Duration, now time.Time) {
+	if leader == "" || leader == nodeURL || remaining <= 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leading {
+		return
+	}
+	e.term = max(e.term, term)
+	e.observedLeader = leader
+	e.observedExpires = now.Add(min(remaining, e.ttl))
+}
+
+// Grant decide um pedido de lease, vindo de outro nó ou do próprio candidato.
+func (e *LeaderElection) Grant(req leaseRequest, now time.Time) leaseResponse {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := now.Before(e.voteExpires)
+	if req.Term < e.term || (current && e.votedFor != req.Candidate) {
+		resp := leaseResponse{Granted: false, Term: e.term}
+		if current {
+			resp.Leader, resp.LeaseMs = e.votedFor, e.voteExpires.Sub(now).Milliseconds()
+		}
+		return resp
+	}
+
+	e.term = req.Term
+	e.votedFor = req.Candidate
+	e.voteExpires = now.Add(e.ttl)
+	return leaseResponse{Granted: true, Leader: req.Candidate, Term: e.term}
+}
+
+func (e *LeaderElection) run() {
+	interval := max(e.ttl/6, 50*time.Millisecond)
+	for {
+		e.tick(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func (e *LeaderElection) tick(now time.Time) {
+	e.mu.Lock()
+	if e.leading && !now.Before(e.leaseExpires) {
+		e.mu.Unlock()
+		e.setLeading(false, now)
+		e.mu.Lock()
+	}
+
+	var term uint64
+	switch {
+	case nodeURL == "":
+		// Fora do cluster não há com quem disputar: a instância lidera sozinha, como fazia
+		// com MASTER=true antes da eleição.
+		leading := e.leading
+		if !leading {
+			e.leaseExpires = now.Add(100 * 365 * 24 * time.Hour)
+		}
+		e.mu.Unlock()
+		if !leading {
+			e.setLeading(true, now)
+		}
+		return
+	case e.leading:
+		if now.Before(e.nextRenew) {
+			e.mu.Unlock()
+			return
+		}
+		term = e.term
+	case now.Before(e.voteExpires) || now.Before(e.observedExpires):
+		// Há um líder vivo; a próxima disputa só começa depois que o lease dele vencer.
+		e.nextCampaign = time.Time{}
+		e.mu.Unlock()
+		return
+	default:
+		if e.nextCampaign.IsZero() {
+			e.nextCampaign = now.Add(e.campaignDelay())
+		}
+		if now.Before(e.nextCampaign) {
+			e.mu.Unlock()
+			return
+		}
+		e.nextCampaign = time.Time{}
+		term = e.term + 1
+	}
+	e.mu.Unlock()
+
+	e.campaign(term, now)
+}
+
+// campaignDelay espalha as candidaturas para evitar empates; o nó preferido sai na frente.
+func (e *LeaderElection) campaignDelay() time.Duration {
+	delay := time.Duration(rand.Int63n(int64(e.ttl/2) + 1))
+	if !e.preferred {
+		delay += e.ttl / 2
+	}
+	return delay
+}
+
+func (e *LeaderElection) campaign(term uint64, start time.Time) {
+	req := leaseRequest{Candidate: nodeURL, Term: term}
+	body, _ := json.Marshal(req)
+
+	voters := membership.Seeds()
+	granted := 0
+	if e.Grant(req, start).Granted && slices.Contains(voters, nodeURL) {
+		granted++
+	}
+	highestTerm := term
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var peers []string
+	for _, node := range voters {
+		if node != nodeURL {
+			peers = append(peers, node)
+		}
+	}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			status, _, respBody, err := sendToPeer("POST", peer, "/internal/cluster/lease", nil, body)
+			if err != nil || status != fiber.StatusOK {
+				return
+			}
+			var resp leaseResponse
+			if err := json.Unmarshal(respBody, &resp); err != nil {
+				return
+			}
+			if !resp.Granted {
+				e.Observe(resp.Leader, resp.Term, time.Duration(resp.LeaseMs)*time.Millisecond, time.Now())
+			}
+			mu.Lock()
+			if resp.Granted {
+				granted++
+			}
+			highestTerm = max(highestTerm, resp.Term)
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+
+	e.mu.Lock()
+	e.term = max(e.term, highestTerm)
+	won := granted >= len(voters)/2+1 && highestTerm == term
+	if won {
+		// O lease conta a partir do envio, então vence aqui antes de vencer em quem concedeu.
+		e.leaseExpires = start.Add(e.ttl)
+		e.nextRenew = start.Add(e.ttl / 3)
+	} else if !e.leading && e.votedFor == nodeURL {
+		// Sem maioria o voto em si mesmo não protege ninguém; libera para votar em outro.
+		e.voteExpires = time.Time{}
+	}
+	leading := e.leading
+	e.mu.Unlock()
+
+	// Uma renovação sem maioria é tentada de novo até o lease vencer; só um termo maior
+	// derruba o líder na hora.
+	switch {
+	case won && !leading:
+		e.setLeading(true, time.Now())
+	case leading && highestTerm > term:
+		e.setLeading(false, time.Now())
+	}
+}
+
+// setLeading liga ou desliga o circuitController; só é chamado pela goroutine de run.
+func (e *LeaderElection) setLeading(leading bool, now time.Time) {
+	e.mu.Lock()
+	e.leading = leading
+	term := e.term
+	if leading {
+		e.controllerStop = make(chan struct{})
+		go circuitController(e.controllerStop, term)
+	} else if e.controllerStop != nil {
+		close(e.controllerStop)
+		e.controllerStop = nil
+	}
+	e.mu.Unlock()
+
+	if leading {
+		fmt.Printf("[%s][ELECTION] %s became leader for term %d\n", formatDate(now.UTC()), nodeURL, term)
+	} else {
+		fmt.Printf("[%s][ELECTION] %s stepped down at term %d\n", formatDate(now.UTC()), nodeURL, term)
+	}
+}
+
+func leaseHandler(c *fiber.Ctx) error {
+	var req leaseRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || req.Candidate == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(election.Grant(req, time.Now()))
+}
+
+func leaderHandler(c *fiber.Ctx) error {
+	election.mu.Lock()
+	term := election.term
+	election.mu.Unlock()
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"node":    nodeURL,
+		"leader":  election.Leader(),
+		"leading": election.Leading(),
+		"term":    term,
+	})
+}
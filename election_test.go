@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestObservedLeader cobre um nó fora dos seeds: ninguém lhe pede lease, então ele só sabe
+// quem lidera pelo que os outros relatam.
+func TestObservedLeader(t *testing.T) {
+	now := time.Now()
+	seed := newLeaderElection(3*time.Second, false)
+	if resp := seed.Grant(leaseRequest{Candidate: "http://api-1:8080", Term: 4}, now); !resp.Granted {
+		t.Fatalf("lease negado: %+v", resp)
+	}
+
+	// Um seed recusa outro candidato e diz quem lidera e por quanto tempo.
+	resp := seed.Grant(leaseRequest{Candidate: "http://api-9:8080", Term: 5}, now.Add(time.Second))
+	if resp.Granted || resp.Leader != "http://api-1:8080" || resp.LeaseMs != 2000 {
+		t.Fatalf("resposta %+v", resp)
+	}
+
+	member := newLeaderElection(3*time.Second, false)
+	if leader := member.Leader(); leader != "" {
+		t.Fatalf("líder %q antes de qualquer relato", leader)
+	}
+
+	leader, term, remaining := seed.LeaderLease(now.Add(time.Second))
+	member.Observe(leader, term, remaining, time.Now())
+	if got := member.Leader(); got != "http://api-1:8080" {
+		t.Fatalf("líder observado %q", got)
+	}
+	if got, term, _ := member.LeaderLease(time.Now()); got != "http://api-1:8080" || term != 4 {
+		t.Fatalf("relato repassado: %q termo %d", got, term)
+	}
+
+	// O relato vale só pelo que faltava do lease.
+	if got, _, _ := member.LeaderLease(time.Now().Add(remaining)); got != "" {
+		t.Fatalf("líder %q depois do lease vencer", got)
+	}
+}
@@ -6,6 +6,14 @@ import (
 	"time"
 )
 
+func getenvString(key string, def string) string {
+	env := os.Getenv(key)
+	if env == "" {
+		return def
+	}
+	return env
+}
+
 func getenvBool(key string, def bool) bool {
 	env, err := strconv.ParseBool(os.Getenv(key))
 	if err != nil {
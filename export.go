@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// /payments-export devolve os pagamentos de [from, to] (opcionais) de todos os nós em NDJSON
+// (padrão) ou CSV (format=csv), por streaming: primeiro os deste nó, depois os de cada outro
+// nó, página por página. O storage é lido em blocos de EXPORT_CHUNK_SIZE, soltando
+// storageMutex entre um bloco e outro, então um export grande não segura o saver. Se um nó
+// falhar no meio, a resposta já começou: o erro vai numa linha própria e o export segue com
+// os outros nós. No NDJSON a linha é {"error": ..., "node": ...}; no CSV ela tem as mesmas
+// quatro colunas, para não quebrar leitores que exigem o número de campos, com
+// exportCSVErrorMarker no lugar do correlationId, amount e requestedAt vazios e
+// "<nó>: <erro>" na coluna do processador.
+const (
+	exportCursorHeader   = "X-Export-Cursor"
+	exportCSVErrorMarker = "#erro"
+)
+
+var exportChunkSize = getenvInt("EXPORT_CHUNK_SIZE", 1000)
+
+type exportRecord struct {
+	CorrelationID string    `json:"correlationId"`
+	Amount        Money     `json:"amount"`
+	RequestedAt   time.Time `json:"requestedAt"`
+	Processor     string    `json:"processor"`
+}
+
+func newExportRecord(p PaymentRequest) exportRecord {
+	return exportRecord{
+		CorrelationID: p.CorrelationID,
+		Amount:        p.Amount,
+		RequestedAt:   p.RequestedAt,
+		Processor:     p.Processor,
+	}
+}
+
+// scanStorage copia até limit pagamentos aceitos por match a partir da posição cursor do
+// storage, segurando o lock de leitura um bloco por vez. Como o storage só cresce, a posição
+// continua válida entre chamadas. Devolve a próxima posição, ou -1 se chegou ao fim.
+func scanStorage(cursor, limit int, match func(PaymentRequest) bool) ([]PaymentRequest, int) {
+	var out []PaymentRequest
+	for len(out) < limit {
+		storageMutex.RLock()
+		end := min(cursor+exportChunkSize, len(storage))
+		for ; cursor < end && len(out) < limit; cursor++ {
+			if match(storage[cursor]) {
+				out = append(out, storage[cursor])
+			}
+		}
+		done := cursor >= len(storage)
+		storageMutex.RUnlock()
+
+		if done {
+			return out, -1
+		}
+	}
+	return out, cursor
+}
+
+// timeRangeFilter lê from/to no formato do resumo; sem os dois, aceita qualquer instante.
+func timeRangeFilter(c *fiber.Ctx) (func(time.Time) bool, error) {
+	fromStr, toStr := c.Query("from"), c.Query("to")
+	if fromStr == "" || toStr == "" {
+		return func(time.Time) bool { return true }, nil
+	}
+	from, err := time.Parse("2006-01-02T15:04:05.000Z", fromStr)
+	if err != nil {
+		return nil, err
+	}
+	to, err := time.Parse("2006-01-02T15:04:05.000Z", toStr)
+	if err != nil {
+		return nil, err
+	}
+	return func(t time.Time) bool { return !t.Before(from) && !t.After(to) }, nil
+}
+
+func exportPaymentsHandler(c *fiber.Ctx) error {
+	inRange, err := timeRangeFilter(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	format := c.Query("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		return c.Status(fiber.StatusBadRequest).SendString("format deve ser ndjson ou csv")
+	}
+
+	var peers []string
+	for _, node := range membership.Nodes() {
+		if node != nodeURL {
+			peers = append(peers, node)
+		}
+	}
+	query := url.Values{}
+	if c.Query("from") != "" && c.Query("to") != "" {
+		query.Set("from", c.Query("from"))
+		query.Set("to", c.Query("to"))
+	}
+
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		out := newExportWriter(w, format)
+
+		cursor := 0
+		for cursor >= 0 {
+			var page []PaymentRequest
+			page, cursor = scanStorage(cursor, exportChunkSize, func(p PaymentRequest) bool { return inRange(p.RequestedAt) })
+			for _, p := range page {
+				out.write(newExportRecord(p))
+			}
+			if out.flush() != nil {
+				return
+			}
+		}
+
+		for _, node := range peers {
+			if err := exportFromPeer(node, query, out); err != nil {
+				fmt.Printf("Erro ao exportar pagamentos de %s: %v\n", node, err)
+				out.fail(node, err)
+			}
+			if out.flush() != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// exportFromPeer copia para out todas as páginas de /internal/payments-export de node.
+func exportFromPeer(node string, query url.Values, out *exportWriter) error {
+	cursor := "0"
+	for cursor != "" {
+		query.Set("cursor", cursor)
+		status, header, body, err := sendToPeer("GET", node, "/internal/payments-export?"+query.Encode(), nil, nil)
+		if err != nil {
+			return err
+		}
+		if status != fiber.StatusOK {
+			return fmt.Errorf("erro HTTP: status %d", status)
+		}
+
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var r exportRecord
+			if err := json.Unmarshal(line, &r); err != nil {
+				return err
+			}
+			out.write(r)
+		}
+		if out.flush() != nil {
+			return nil
+		}
+		cursor = header.Get(exportCursorHeader)
+	}
+	return nil
+}
+
+// exportPageHandler é a rota interna: uma página de NDJSON a partir de cursor, com a próxima
+// posição em X-Export-Cursor (vazio no fim).
+func exportPageHandler(c *fiber.Ctx) error {
+	inRange, err := timeRangeFilter(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	cursor, err := strconv.Atoi(c.Query("cursor", "0"))
+	if err != nil || cursor < 0 {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	page, next := scanStorage(cursor, exportChunkSize, func(p PaymentRequest) bool { return inRange(p.RequestedAt) })
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, p := range page {
+		enc.Encode(newExportRecord(p))
+	}
+	if next >= 0 {
+		c.Set(exportCursorHeader, strconv.Itoa(next))
+	}
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+type exportWriter struct {
+	w   *bufio.Writer
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+func newExportWriter(w *bufio.Writer, format string) *exportWriter {
+	out := &exportWriter{w: w}
+	if format == "csv" {
+		out.csv = csv.NewWriter(w)
+		out.csv.Write([]string{"correlationId", "amount", "requestedAt", "processor"})
+	} else {
+		out.enc = json.NewEncoder(w)
+	}
+	return out
+}
+
+func (e *exportWriter) write(r exportRecord) {
+	if e.csv != nil {
+		e.csv.Write([]string{r.CorrelationID, r.Amount.String(), formatDate(r.RequestedAt.UTC()), r.Processor})
+		return
+	}
+	e.enc.Encode(r)
+}
+
+func (e *exportWriter) fail(node string, err error) {
+	if e.csv != nil {
+		e.csv.Write([]string{exportCSVErrorMarker, "", "", fmt.Sprintf("%s: %v", node, err)})
+		return
+	}
+	e.enc.Encode(fiber.Map{"error": err.Error(), "node": node})
+}
+
+// flush manda o que foi escrito ao cliente; erro indica que a conexão caiu.
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+	}
+	return e.w.Flush()
+}
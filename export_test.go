@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExportCSVErrorRowKeepsFieldCount(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	out := newExportWriter(w, "csv")
+	at := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	out.write(exportRecord{CorrelationID: "a", Amount: 1990, RequestedAt: at, Processor: "default"})
+	out.fail("http://api-2:8080", errors.New("erro HTTP: status 503"))
+	out.write(exportRecord{CorrelationID: "b", Amount: 10, RequestedAt: at, Processor: "fallback"})
+	if err := out.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV inválido: %v\n%s", err, buf.String())
+	}
+	if len(rows) != 4 {
+		t.Fatalf("%d linhas, want 4: %v", len(rows), rows)
+	}
+	if row := rows[2]; row[0] != exportCSVErrorMarker || row[1] != "" || row[3] != "http://api-2:8080: erro HTTP: status 503" {
+		t.Fatalf("linha de erro %q", row)
+	}
+	if _, err := parseMoney(rows[2][1]); err == nil {
+		t.Fatal("a linha de erro tem um amount válido")
+	}
+}
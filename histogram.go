@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Buckets log-lineares no estilo HDR: valores abaixo de 64ms têm bucket exato e, acima disso,
+// cada potência de 2 é dividida em 16 sub-buckets (erro relativo de no máximo ~6%). O último
+// bucket absorve tudo acima de ~17 minutos.
+const (
+	histLinearBuckets = 64
+	histSubBits       = 4
+	histSubBuckets    = 1 << histSubBits
+	histMaxExponent   = 20
+	histBuckets       = histLinearBuckets + (histMaxExponent-6)*histSubBuckets
+)
+
+type histogramSlot struct {
+	epoch  int64
+	count  uint64
+	failed uint64
+	max    int64
+	counts [histBuckets]uint64
+}
+
+// LatencyHistogram guarda latências em ms numa janela deslizante de slots; slots mais velhos
+// que a janela são descartados ao serem reaproveitados, então a memória é fixa. Pode ser
+// escrito pelos workers e lido pelo controlador e pelo endpoint de métricas ao mesmo tempo.
+type LatencyHistogram struct {
+	mu           sync.Mutex
+	slotDuration time.Duration
+	slots        []histogramSlot
+}
+
+type LatencySummary struct {
+	Count       uint64  `json:"count"`
+	Failed      uint64  `json:"failed"`
+	SuccessRate float64 `json:"successRate"`
+	P50         int64   `json:"p50"`
+	P90         int64   `json:"p90"`
+	P95         int64   `json:"p95"`
+	P99         int64   `json:"p99"`
+	P999        int64   `json:"p999"`
+}
+
+func newLatencyHistogram(window time.Duration, slots int) *LatencyHistogram {
+	slots = max(slots, 1)
+	return &LatencyHistogram{
+		slotDuration: max(window/time.Duration(slots), time.Millisecond),
+		slots:        make([]histogramSlot, slots),
+	}
+}
+
+func (h *LatencyHistogram) Record(durationMs int64, failed bool) {
+	h.RecordAt(time.Now(), durationMs, failed)
+}
+
+func (h *LatencyHistogram) RecordAt(now time.Time, durationMs int64, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	slot := h.slotFor(now)
+	slot.count++
+	slot.counts[histBucketIndex(durationMs)]++
+	slot.max = max(slot.max, durationMs)
+	if failed {
+		slot.failed++
+	}
+}
+
+func (h *LatencyHistogram) Reset() {
+	h.mu.Lock()
+	for i := range h.slots {
+		h.slots[i] = histogramSlot{}
+	}
+	h.mu.Unlock()
+}
+
+// Quantile devolve o limite superior do bucket que contém o quantil q (0 < q <= 1), ou 0 sem amostras.
+func (h *LatencyHistogram) Quantile(q float64) int64 {
+	return h.Quantiles(time.Now(), q)[0]
+}
+
+func (h *LatencyHistogram) Quantiles(now time.Time, qs ...float64) []int64 {
+	var merged [histBuckets]uint64
+	total, _, maxValue := h.merge(now, &merged)
+	return histQuantiles(&merged, total, maxValue, qs)
+}
+
+func (h *LatencyHistogram) Summary(now time.Time) LatencySummary {
+	var merged [histBuckets]uint64
+	total, failed, maxValue := h.merge(now, &merged)
+
+	s := LatencySummary{Count: total, Failed: failed, SuccessRate: 1}
+	if total == 0 {
+		return s
+	}
+	s.SuccessRate = 1 - float64(failed)/float64(total)
+	q := histQuantiles(&merged, total, maxValue, []float64{0.5, 0.9, 0.95, 0.99, 0.999})
+	s.P50, s.P90, s.P95, s.P99, s.P999 = q[0], q[1], q[2], q[3], q[4]
+	return s
+}
+
+// merge soma os slots que ainda estão dentro da janela.
+func (h *LatencyHistogram) merge(now time.Time, into *[histBuckets]uint64) (total, failed uint64, maxValue int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	current := now.UnixNano() / int64(h.slotDuration)
+	oldest := current - int64(len(h.slots)) + 1
+	for i := range h.slots {
+		slot := &h.slots[i]
+		if slot.count == 0 || slot.epoch < oldest || slot.epoch > current {
+			continue
+		}
+		total += slot.count
+		failed += slot.failed
+		maxValue = max(maxValue, slot.max)
+		for b, c := range slot.counts {
+			into[b] += c
+		}
+	}
+	return total, failed, maxValue
+}
+
+func (h *LatencyHistogram) slotFor(now time.Time) *histogramSlot {
+	epoch := now.UnixNano() / int64(h.slotDuration)
+	slot := &h.slots[int(epoch%int64(len(h.slots)))]
+	if slot.epoch != epoch {
+		*slot = histogramSlot{epoch: epoch}
+	}
+	return slot
+}
+
+// histQuantiles limita o resultado ao maior valor observado, para que o topo não seja
+// inflado pelo limite superior do bucket.
+func histQuantiles(counts *[histBuckets]uint64, total uint64, maxValue int64, qs []float64) []int64 {
+	out := make([]int64, len(qs))
+	if total == 0 {
+		return out
+	}
+	for i, q := range qs {
+		rank := uint64(q*float64(total) + 0.999999)
+		rank = min(max(rank, 1), total)
+
+		var seen uint64
+		for b, c := range counts {
+			seen += c
+			if seen >= rank {
+				out[i] = min(histBucketUpper(b), maxValue)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func histBucketIndex(v int64) int {
+	if v < histLinearBuckets {
+		return int(max(v, 0))
+	}
+	exp := bits.Len64(uint64(v)) - 1
+	sub := int(v>>(exp-histSubBits)) & (histSubBuckets - 1)
+	return min(histLinearBuckets+(exp-6)*histSubBuckets+sub, histBuckets-1)
+}
+
+func histBucketUpper(idx int) int64 {
+	if idx < histLinearBuckets {
+		return int64(idx)
+	}
+	exp := (idx-histLinearBuckets)/histSubBuckets + 6
+	sub := int64((idx - histLinearBuckets) % histSubBuckets)
+	lower := (histSubBuckets + sub) << (exp - histSubBits)
+	return lower + (1 << (exp - histSubBits)) - 1
+}
+
+// latencyStatsHandler expõe os quantis da janela atual de cada processador, medidos por este
+// nó e, no líder, pelo cluster inteiro.
+func latencyStatsHandler(c *fiber.Ctx) error {
+	now := time.Now()
+	out := make([]fiber.Map, len(processors))
+	for i, p := range processors {
+		out[i] = fiber.Map{
+			"processor":      p.Name,
+			"maxLatency":     p.MaxLatency,
+			"latency":        p.latency.Summary(now),
+			"clusterLatency": p.clusterLatency.Summary(now),
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(out)
+}
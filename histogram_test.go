@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	prev := -1
+	for v := int64(0); v < 1<<histMaxExponent; v++ {
+		idx := histBucketIndex(v)
+		if idx < prev {
+			t.Fatalf("bucket de %d (%d) menor que o de %d (%d)", v, idx, v-1, prev)
+		}
+		prev = idx
+
+		upper := histBucketUpper(idx)
+		if v < histLinearBuckets && upper != v {
+			t.Fatalf("%d caiu no bucket %d com limite %d, want exato", v, idx, upper)
+		}
+		if upper < v || float64(upper-v) > float64(v)/histSubBuckets {
+			t.Fatalf("%d caiu no bucket %d com limite %d", v, idx, upper)
+		}
+	}
+	// Acima do último bucket tudo vai para ele.
+	if idx := histBucketIndex(1 << 40); idx != histBuckets-1 {
+		t.Fatalf("bucket de 2^40 = %d, want %d", idx, histBuckets-1)
+	}
+}
+
+func TestHistogramQuantilesMatchExact(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	h := newLatencyHistogram(10*time.Second, 10)
+
+	values := make([]int64, 10000)
+	for i := range values {
+		// Cauda longa: a maioria rápida, alguns na casa dos segundos.
+		values[i] = int64(rng.ExpFloat64() * 40)
+		if i%100 == 0 {
+			values[i] = 1000 + rng.Int63n(4000)
+		}
+		h.RecordAt(now, values[i], i%10 == 0)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	qs := []float64{0.5, 0.9, 0.95, 0.99, 0.999, 1}
+	got := h.Quantiles(now, qs...)
+	for i, q := range qs {
+		exact := values[int(q*float64(len(values))+0.999999)-1]
+		if got[i] < exact || float64(got[i]-exact) > float64(exact)/histSubBuckets {
+			t.Errorf("p%v = %d, exato %d", q*100, got[i], exact)
+		}
+	}
+	if got[len(got)-1] != values[len(values)-1] {
+		t.Errorf("p100 = %d, want o máximo %d", got[len(got)-1], values[len(values)-1])
+	}
+
+	s := h.Summary(now)
+	if s.Count != 10000 || s.Failed != 1000 || s.SuccessRate != 0.9 {
+		t.Fatalf("summary %+v", s)
+	}
+}
+
+func TestHistogramWindowSlides(t *testing.T) {
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	h := newLatencyHistogram(time.Second, 10)
+
+	for i := 0; i < 100; i++ {
+		h.RecordAt(now, 500, true)
+	}
+	now = now.Add(500 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		h.RecordAt(now, 10, false)
+	}
+
+	if s := h.Summary(now); s.Count != 200 || s.P99 != 500 {
+		t.Fatalf("dentro da janela: %+v", s)
+	}
+
+	// Passada a janela dos primeiros, só os últimos contam.
+	now = now.Add(600 * time.Millisecond)
+	if s := h.Summary(now); s.Count != 100 || s.P99 != 10 || s.SuccessRate != 1 {
+		t.Fatalf("janela deslizada: %+v", s)
+	}
+
+	// O slot reaproveitado não carrega as amostras antigas.
+	h.RecordAt(now, 20, false)
+	now = now.Add(500 * time.Millisecond)
+	if s := h.Summary(now); s.Count != 1 || s.P50 != 20 {
+		t.Fatalf("slot reaproveitado: %+v", s)
+	}
+
+	now = now.Add(2 * time.Second)
+	if s := h.Summary(now); s.Count != 0 || s.P95 != 0 || s.SuccessRate != 1 {
+		t.Fatalf("janela vazia: %+v", s)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// forwardedPaymentHeader marca uma requisição de /payments repassada pelo nó que a recebeu
+// ao nó dono do correlationId; o dono nunca repassa de novo.
+const forwardedPaymentHeader = "X-Forwarded-Payment"
+
+// forwardToOwner repassa a requisição (POST /payments ou GET /payments/:id) ao nó dono do
+// correlationId, para que deduplicação e consulta valham entre todas as instâncias. Devolve
+// false se a requisição deve ser tratada localmente: este nó é o dono, já é um repasse ou está
+// fora do cluster (sem NODE_URL). Se o repasse falhar, a resposta é 503 com Retry-After e o
+// cliente repete com o mesmo correlationId. Processar aqui não é seguro nem com o dono fora do
+// ar: a repetição pode cair em outro nó e ser cobrada de novo, e o dono, ao voltar, não
+// saberia do pagamento.
+func forwardToOwner(c *fiber.Ctx, id string) (bool, error) {
+	if c.Get(forwardedPaymentHeader) != "" {
+		// Só outro nó pode pular o repasse; um cliente que mande o header sem assinatura é recusado.
+		if err := verifyInternal(c); err != nil {
+			return true, c.Status(fiber.StatusUnauthorized).SendString(err.Error())
+		}
+		return false, nil
+	}
+	owner := ownerOf(id)
+	if owner == "" || owner == nodeURL || nodeURL == "" {
+		return false, nil
+	}
+
+	header := http.Header{}
+	header.Set(forwardedPaymentHeader, nodeURL)
+	status, respHeader, body, err := sendToPeer(c.Method(), owner, c.OriginalURL(), header, c.Body())
+	if err != nil {
+		fmt.Printf("Erro ao repassar %s %s para %s: %v\n", c.Method(), c.OriginalURL(), owner, err)
+		return true, rejectUnavailable(c, "dono do pagamento não respondeu")
+	}
+
+	for _, h := range []string{fiber.HeaderContentType, fiber.HeaderRetryAfter, queueDepthHeader} {
+		if v := respHeader.Get(h); v != "" {
+			c.Set(h, v)
+		}
+	}
+	return true, c.Status(status).Send(body)
+}
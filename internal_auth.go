@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Chamadas entre nós (rotas em /internal e repasses de /payments) são assinadas com
+// HMAC-SHA256 sobre método, caminho com query, instante e hash do corpo, usando o segredo
+// compartilhado INTERNAL_SECRET. Quem recebe recusa assinaturas inválidas ou com mais de
+// INTERNAL_AUTH_SKEW_MS de diferença do relógio local. Sem INTERNAL_SECRET a instância não
+// sobe. As rotas administrativas que alteram estado exigem a mesma assinatura.
+const internalSignatureHeader = "X-Internal-Signature"
+
+var (
+	internalSecret   = []byte(getenvString("INTERNAL_SECRET", ""))
+	internalAuthSkew = getenvDurationMS("INTERNAL_AUTH_SKEW_MS", 5000)
+
+	errMissingSignature = errors.New("assinatura interna ausente")
+	errInvalidSignature = errors.New("assinatura interna inválida")
+	errExpiredSignature = errors.New("assinatura interna expirada")
+)
+
+func signInternal(method, requestURI string, timestamp int64, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, internalSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n", method, requestURI, timestamp)
+	mac.Write(bodyHash[:])
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest assina uma requisição para outro nó; body tem que ser o corpo que será enviado.
+func signRequest(req *http.Request, body []byte) {
+	timestamp := time.Now().UnixMilli()
+	signature := signInternal(req.Method, req.URL.RequestURI(), timestamp, body)
+	req.Header.Set(internalSignatureHeader, fmt.Sprintf("t=%d,sig=%s", timestamp, signature))
+}
+
+func verifyInternal(c *fiber.Ctx) error {
+	header := c.Get(internalSignatureHeader)
+	if header == "" {
+		return errMissingSignature
+	}
+
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "sig":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return errInvalidSignature
+	}
+	if skew := time.Since(time.UnixMilli(timestamp)); skew > internalAuthSkew || skew < -internalAuthSkew {
+		return errExpiredSignature
+	}
+
+	expected := signInternal(c.Method(), string(c.Request().URI().RequestURI()), timestamp, c.Body())
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// requireInternalAuth protege o grupo /internal.
+func requireInternalAuth(c *fiber.Ctx) error {
+	if err := verifyInternal(c); err != nil {
+		return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
+	}
+	return c.Next()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func internalAuthApp(t *testing.T) *fiber.App {
+	t.Helper()
+	savedSecret := internalSecret
+	t.Cleanup(func() { internalSecret = savedSecret })
+	internalSecret = []byte("segredo-de-teste")
+
+	app := fiber.New()
+	app.Group("/internal", requireInternalAuth).Post("/echo", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	// Sem NODE_URL todo correlationId é local, então o 200 só mostra se o repasse foi aceito.
+	app.Post("/payments", func(c *fiber.Ctx) error {
+		if forwarded, err := forwardToOwner(c, "p-0001"); forwarded {
+			return err
+		}
+		return c.SendString("ok")
+	})
+	return app
+}
+
+func TestInternalAuth(t *testing.T) {
+	app := internalAuthApp(t)
+	body := []byte(`{"correlationId":"p-0001","amount":19.90}`)
+
+	cases := []struct {
+		name    string
+		prepare func(req *http.Request)
+		want    int
+	}{
+		{"assinada", func(req *http.Request) { signRequest(req, body) }, fiber.StatusOK},
+		{"sem header", func(req *http.Request) {}, fiber.StatusUnauthorized},
+		{"corpo alterado", func(req *http.Request) {
+			signRequest(req, append([]byte(nil), body[:len(body)-2]...))
+		}, fiber.StatusUnauthorized},
+		{"query alterada", func(req *http.Request) {
+			signRequest(req, body)
+			req.URL.RawQuery = "retry=1"
+			req.RequestURI = req.URL.RequestURI()
+		}, fiber.StatusUnauthorized},
+		{"expirada", func(req *http.Request) {
+			timestamp := time.Now().Add(-2 * internalAuthSkew).UnixMilli()
+			signature := signInternal(req.Method, req.URL.RequestURI(), timestamp, body)
+			req.Header.Set(internalSignatureHeader, fmt.Sprintf("t=%d,sig=%s", timestamp, signature))
+		}, fiber.StatusUnauthorized},
+	}
+
+	for _, path := range []string{"/internal/echo", "/payments"} {
+		for _, tc := range cases {
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			if path == "/payments" {
+				req.Header.Set(forwardedPaymentHeader, "http://outro-no:8080")
+			}
+			tc.prepare(req)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("%s %s: status %d (%s), want %d", path, tc.name, resp.StatusCode, msg, tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type paymentState int32
+
+const (
+	stateAccepted paymentState = iota
+	stateInFlight
+	stateRetrying
+	stateProcessedDefault
+	stateProcessedFallback
+	stateFailed
+)
+
+func (s paymentState) String() string {
+	switch s {
+	case stateAccepted:
+		return "accepted"
+	case stateInFlight:
+		return "in-flight"
+	case stateRetrying:
+		return "retrying"
+	case stateProcessedDefault:
+		return "processed-default"
+	case stateProcessedFallback:
+		return "processed-fallback"
+	case stateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+func parsePaymentState(name string) (paymentState, bool) {
+	for s := stateAccepted; s <= stateFailed; s++ {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func (s paymentState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type PaymentStatus struct {
+	CorrelationID string       `json:"correlationId"`
+	Amount        Money        `json:"amount"`
+	State         paymentState `json:"status"`
+	Processor     string       `json:"processor,omitempty"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"lastError,omitempty"`
+	AcceptedAt    time.Time    `json:"acceptedAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+	RequestedAt   *time.Time   `json:"requestedAt,omitempty"`
+}
+
+// tracker acompanha cada pagamento aceito por este nó e serve tanto de índice para
+// GET /payments/:id quanto de registro de idempotência para POST /payments.
+var tracker = newPaymentTracker()
+
+type PaymentTracker struct {
+	mu      sync.RWMutex
+	entries map[string]*PaymentStatus
+}
+
+func newPaymentTracker() *PaymentTracker {
+	return &PaymentTracker{entries: make(map[string]*PaymentStatus)}
+}
+
+// Claim registra o pagamento como aceito. Se o correlationId já era conhecido, devolve o estado atual e false.
+func (t *PaymentTracker) Claim(p PaymentRequest) (PaymentStatus, bool) {
+	now := time.Now().UTC()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.entries[p.CorrelationID]; ok {
+		return *s, false
+	}
+	s := &PaymentStatus{
+		CorrelationID: p.CorrelationID,
+		Amount:        p.Amount,
+		State:         stateAccepted,
+		AcceptedAt:    now,
+		UpdatedAt:     now,
+	}
+	t.entries[p.CorrelationID] = s
+	return *s, true
+}
+
+// Release esquece um pagamento que não chegou a entrar na fila, para que o cliente possa reenviá-lo.
+func (t *PaymentTracker) Release(id string) {
+	t.mu.Lock()
+	delete(t.entries, id)
+	t.mu.Unlock()
+}
+
+// Start marca o início de uma tentativa de envio a um processador.
+func (t *PaymentTracker) Start(id string) {
+	t.update(id, func(s *PaymentStatus) {
+		s.State = stateInFlight
+		s.Attempts++
+	})
+}
+
+func (t *PaymentTracker) Retry(id string, err error) {
+	t.update(id, func(s *PaymentStatus) {
+		s.State = stateRetrying
+		s.LastError = err.Error()
+	})
+}
+
+func (t *PaymentTracker) Fail(id string, err error) {
+	t.update(id, func(s *PaymentStatus) {
+		s.State = stateFailed
+		s.LastError = err.Error()
+	})
+}
+
+// Requeue devolve ao estado aceito um pagamento reenviado manualmente (replay da dead-letter queue).
+func (t *PaymentTracker) Requeue(id string) {
+	t.update(id, func(s *PaymentStatus) {
+		s.State = stateAccepted
+		s.Attempts = 0
+	})
+}
+
+func (t *PaymentTracker) Processed(p PaymentRequest) {
+	t.update(p.CorrelationID, func(s *PaymentStatus) {
+		s.State = stateProcessedDefault
+		if p.Fallback {
+			s.State = stateProcessedFallback
+		}
+		s.Processor = p.Processor
+		requestedAt := p.RequestedAt
+		s.RequestedAt = &requestedAt
+	})
+}
+
+// Restore recria o estado de um pagamento já persistido (replay do WAL/snapshot).
+func (t *PaymentTracker) Restore(p PaymentRequest) {
+	state := stateProcessedDefault
+	if p.Fallback {
+		state = stateProcessedFallback
+	}
+	requestedAt := p.RequestedAt
+
+	t.mu.Lock()
+	t.entries[p.CorrelationID] = &PaymentStatus{
+		CorrelationID: p.CorrelationID,
+		Amount:        p.Amount,
+		State:         state,
+		Processor:     p.Processor,
+		AcceptedAt:    requestedAt,
+		UpdatedAt:     requestedAt,
+		RequestedAt:   &requestedAt,
+	}
+	t.mu.Unlock()
+}
+
+// RestoreDeadLetter recria o estado de um pagamento que estava na dead-letter queue no
+// último shutdown. Devolve false se o correlationId já é conhecido (processado no WAL ou
+// pendente).
+func (t *PaymentTracker) RestoreDeadLetter(dl DeadLetter) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.entries[dl.Payment.CorrelationID]; ok {
+		return false
+	}
+	t.entries[dl.Payment.CorrelationID] = &PaymentStatus{
+		CorrelationID: dl.Payment.CorrelationID,
+		Amount:        dl.Payment.Amount,
+		State:         stateFailed,
+		Attempts:      dl.Attempts,
+		LastError:     dl.LastError,
+		AcceptedAt:    dl.FailedAt,
+		UpdatedAt:     dl.FailedAt,
+	}
+	return true
+}
+
+func (t *PaymentTracker) Get(id string) (PaymentStatus, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	s, ok := t.entries[id]
+	if !ok {
+		return PaymentStatus{}, false
+	}
+	return *s, true
+}
+
+// Find devolve uma cópia de todos os pagamentos aceitos por match.
+func (t *PaymentTracker) Find(match func(s *PaymentStatus) bool) []PaymentStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var out []PaymentStatus
+	for _, s := range t.entries {
+		if match(s) {
+			out = append(out, *s)
+		}
+	}
+	return out
+}
+
+func (t *PaymentTracker) update(id string, fn func(s *PaymentStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.entries[id]
+	if !ok {
+		return
+	}
+	fn(s)
+	s.UpdatedAt = time.Now().UTC()
+}
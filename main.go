@@ -1,66 +1,123 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"log"
-	"net/http"
-	"net/url"
-	"os"
+	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 type PaymentRequest struct {
 	CorrelationID string    `json:"correlationId"`
-	Amount        float64   `json:"amount"`
+	Amount        Money     `json:"amount"`
 	RequestedAt   time.Time `json:"requestedAt"`
+	Processor     string    `json:"processor,omitempty"`
 	Fallback      bool
+
+	attempts int
 }
 
 var (
 	saveChan     = make(chan PaymentRequest, 10000)
-	storage      = make([]PaymentRequest, 10000)
+	storage      = make([]PaymentRequest, 0, 10000)
 	storageMutex sync.RWMutex
-	isMaster     = false
 )
 
 func main() {
+	if len(internalSecret) == 0 {
+		log.Fatal("INTERNAL_SECRET não definido: as rotas internas exigem um segredo compartilhado")
+	}
+	if getenvBool("WAL_ENABLED", true) {
+		initWAL()
+	}
+	summaryIndex.AddAll(storage)
+	for _, p := range storage {
+		tracker.Restore(p)
+	}
+	restorePending()
+	restoreDeadLetters()
+
 	numWorkers := getenvInt("NUM_WORKERS", 10)
+	workersDone.Add(numWorkers)
 	for i := 1; i <= numWorkers; i++ {
 		go worker()
 	}
 
-	if os.Getenv("MASTER") == "true" {
-		isMaster = true
+	if _, err := newRoutingPolicy(routingPolicyName, loadRoutingConfig()); err != nil {
+		log.Fatal(err)
 	}
-	if isMaster {
-		fmt.Println("Starting circuit controller")
-		go circuitController()
+	if nodeURL == "" {
+		fmt.Println("NODE_URL não definido: esta instância fica fora do cluster e roda o controlador sozinha")
 	}
+	go membership.run()
+	go election.run()
+	go reconcileCircuit()
+	go pushMetrics()
 
 	go startInMemorySaver()
+	go retryScheduler.run()
 
+	fiber.SetParserDecoder(fiber.ParserConfig{
+		IgnoreUnknownKeys: true,
+		ZeroEmpty:         true,
+		ParserType:        []fiber.ParserType{moneyFormParser},
+	})
 	app := fiber.New()
-	app.Post("/circuit/:status", func(c *fiber.Ctx) error {
-		status, err := c.ParamsInt("status", 0)
-		if err != nil {
-			status = 0
-		}
-
-		atomic.StoreInt32(&circuitStatusFlag, int32(status))
+	app.Get("/cluster/leader", leaderHandler)
+	app.Get("/cluster/members", membersHandler)
+
+	// Rotas chamadas só por outros nós, com assinatura HMAC (internal_auth.go).
+	internal := app.Group("/internal", requireInternalAuth)
+	internal.Post("/cluster/lease", leaseHandler)
+	internal.Post("/cluster/heartbeat", heartbeatHandler)
+	internal.Post("/cluster/metrics", receiveMetricsHandler)
+	internal.Get("/circuit/state", getCircuitStateHandler)
+	internal.Post("/circuit/state", updateCircuitStateHandler)
+	internal.Get("/payments-summary", paymentsSummaryHandler(true))
+	internal.Get("/dead-letters", listLocalDeadLettersHandler)
+	internal.Post("/dead-letters/replay", replayLocalDeadLettersHandler)
+	internal.Get("/payments-export", exportPageHandler)
+	internal.Get("/payments-search", searchPageHandler)
 
-		return nil
-	})
 	app.Post("/payments", func(c *fiber.Ctx) error {
+		if draining.Load() {
+			return rejectUnavailable(c, "instância em desligamento")
+		}
+
 		var paymentRequest PaymentRequest
 		if err := c.BodyParser(&paymentRequest); err != nil {
 			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 		}
 
-		queue <- &paymentRequest
+		// No formulário o Fiber devolve strings que apontam para o buffer da requisição,
+		// reaproveitado depois dela; o correlationId fica guardado no tracker e na fila.
+		paymentRequest.CorrelationID = strings.Clone(paymentRequest.CorrelationID)
+		id := paymentRequest.CorrelationID
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("correlationId obrigatório")
+		}
+		if paymentRequest.Amount <= 0 {
+			return c.Status(fiber.StatusBadRequest).SendString("amount precisa ser maior que zero")
+		}
+
+		if forwarded, err := forwardToOwner(c, id); forwarded {
+			return err
+		}
+
+		// Duplicatas não voltam para a fila: respondem 200 com o estado atual do pagamento.
+		if status, ok := tracker.Claim(paymentRequest); !ok {
+			return c.Status(fiber.StatusOK).JSON(status)
+		}
+
+		accepted := admit(&paymentRequest)
+		c.Set(queueDepthHeader, strconv.Itoa(len(queue)))
+		if !accepted {
+			tracker.Release(id)
+			return rejectUnavailable(c, "fila de pagamentos saturada")
+		}
 		return c.SendStatus(fiber.StatusCreated)
 	})
 	app.Get("/payments-summary-random", func(c *fiber.Ctx) error {
@@ -89,143 +146,30 @@ func main() {
 	app.Get("/payments/:id", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
-		for _, paymentRequest := range storage {
-			if paymentRequest.CorrelationID == id {
-				return c.Status(fiber.StatusOK).JSON(paymentRequest)
-			}
+		if forwarded, err := forwardToOwner(c, id); forwarded {
+			return err
 		}
-		return c.SendStatus(fiber.StatusNotFound)
-	})
-	app.Get("/payments-summary", func(c *fiber.Ctx) error {
-		fromStr := c.Query("from")
-		toStr := c.Query("to")
-		internal := c.Query("internal", "false")
-
-		var from, to time.Time
-		var err error
-		useDateFilter := false
-
-		if fromStr != "" && toStr != "" {
-			from, err = time.Parse("2006-01-02T15:04:05.000Z", fromStr)
-			if err != nil {
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
-
-			to, err = time.Parse("2006-01-02T15:04:05.000Z", toStr)
-			if err != nil {
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
 
-			useDateFilter = true
+		if status, ok := tracker.Get(id); ok {
+			return c.Status(fiber.StatusOK).JSON(status)
 		}
-
-		fmt.Printf("[%s][SUMMARY-REQUEST] Receiving request with from: %v and %v\n",
-			getUTCNowFormatted(), formatDate(from), formatDate(to))
-
-		var defCount, fbCount int
-		var defTotal, fbTotal float64
-
-		if !isMaster && internal != "true" {
-			defCount, defTotal, fbCount, fbTotal = fetchPaymentSummary(from, to, useDateFilter, false, masterURL)
-		} else {
-			defCount, defTotal, fbCount, fbTotal = getPaymentSummary(from, to, useDateFilter)
-			if internal != "true" {
-				for _, slaveURL := range slavesURL {
-					defCountIntern, defTotalIntern, fbCountIntern, fbTotalIntern := fetchPaymentSummary(from, to, useDateFilter, true, slaveURL)
-					defCount += defCountIntern
-					defTotal += defTotalIntern
-					fbCount += fbCountIntern
-					fbTotal += fbTotalIntern
-				}
-			}
-		}
-
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"default": fiber.Map{
-				"totalRequests": defCount,
-				"totalAmount":   defTotal,
-			},
-			"fallback": fiber.Map{
-				"totalRequests": fbCount,
-				"totalAmount":   fbTotal,
-			},
-		})
+		return c.SendStatus(fiber.StatusNotFound)
 	})
-
-	log.Fatal(app.Listen(":8080"))
-}
-
-func getPaymentSummary(from, to time.Time, useDateFilter bool) (
-	defaultCount int, defaultAmount float64, fallbackCount int, fallbackAmount float64) {
-	storageMutex.RLock()
-	defer storageMutex.RUnlock()
-
-	for _, p := range storage {
-		if useDateFilter {
-			if p.RequestedAt.Before(from) || p.RequestedAt.After(to) {
-				continue
-			}
-		}
-
-		if p.Fallback {
-			fallbackCount++
-			fallbackAmount += p.Amount
-		} else {
-			defaultCount++
-			defaultAmount += p.Amount
-		}
+	app.Get("/admin/admission", admissionStatsHandler)
+	app.Get("/admin/latency", latencyStatsHandler)
+	app.Get("/admin/dead-letters", listDeadLettersHandler)
+	app.Post("/admin/dead-letters/replay", requireInternalAuth, replayAllDeadLettersHandler)
+	app.Get("/admin/dead-letters/:id", getDeadLetterHandler)
+	app.Post("/admin/dead-letters/:id/replay", requireInternalAuth, replayDeadLetterHandler)
+	app.Get("/payments-summary", paymentsSummaryHandler(false))
+	app.Get("/payments-export", exportPaymentsHandler)
+	app.Get("/payments-search", searchPaymentsHandler)
+
+	go awaitShutdown(app)
+	if err := app.Listen(":8080"); err != nil {
+		log.Fatal(err)
 	}
-
-	return
-}
-
-func fetchPaymentSummary(from, to time.Time, useDateFilter, internal bool, baseUrl string) (defCount int, defTotal float64, fbCount int, fbTotal float64) {
-	endpoint, err := url.Parse(baseUrl + "/payments-summary")
-	if err != nil {
-		fmt.Println(fmt.Errorf("erro ao montar URL: %w", err))
-		return 0, 0, 0, 0
-	}
-
-	q := endpoint.Query()
-	if useDateFilter {
-		q.Set("from", from.Format("2006-01-02T15:04:05.000Z"))
-		q.Set("to", to.Format("2006-01-02T15:04:05.000Z"))
-	}
-	if internal {
-		q.Set("internal", "true")
-	}
-	endpoint.RawQuery = q.Encode()
-
-	resp, err := circuitClient.Get(endpoint.String())
-	if err != nil {
-		fmt.Println(fmt.Errorf("erro na requisição: %w", err))
-		return 0, 0, 0, 0
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println(fmt.Errorf("erro HTTP: status %s", resp.Status))
-		return 0, 0, 0, 0
-	}
-
-	var parsed struct {
-		Default struct {
-			TotalRequests int     `json:"totalRequests"`
-			TotalAmount   float64 `json:"totalAmount"`
-		} `json:"default"`
-		Fallback struct {
-			TotalRequests int     `json:"totalRequests"`
-			TotalAmount   float64 `json:"totalAmount"`
-		} `json:"fallback"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
-		fmt.Println(fmt.Errorf("erro ao decodificar JSON: %w", err))
-		return 0, 0, 0, 0
-	}
-
-	return parsed.Default.TotalRequests, parsed.Default.TotalAmount,
-		parsed.Fallback.TotalRequests, parsed.Fallback.TotalAmount
+	<-shutdownDone
 }
 
 func getUTCNowFormatted() string {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultClusterSeeds = "http://api-1:8080,http://api-2:8080,http://api-3:8080"
+
+// membership mantém a lista de nós vivos. Cada nó manda um heartbeat a cada
+// MEMBERSHIP_HEARTBEAT_MS para os seeds (CLUSTER_SEEDS) e para todo nó que conhece; o
+// primeiro heartbeat funciona como registro. Quem recebe ou responde um heartbeat está vivo
+// por MEMBERSHIP_TIMEOUT_MS. Os nós trocam suas listas, então um nó novo só precisa
+// conhecer um seed. Nós só citados por outros são contatados, mas só entram na lista
+// depois de um contato direto, senão um nó morto seria mantido vivo pelos relatos dos outros.
+var membership = newMembership(
+	splitNodes(getenvString("CLUSTER_SEEDS", defaultClusterSeeds)),
+	getenvDurationMS("MEMBERSHIP_HEARTBEAT_MS", 1000),
+	getenvDurationMS("MEMBERSHIP_TIMEOUT_MS", 3500),
+)
+
+type heartbeatMessage struct {
+	Node    string   `json:"node"`
+	Members []string `json:"members"`
+	// Leader, Term e LeaseMs relatam o líder conhecido por Node; é assim que nós fora dos
+	// seeds, que não votam, ficam sabendo quem lidera.
+	Leader  string `json:"leader,omitempty"`
+	Term    uint64 `json:"term,omitempty"`
+	LeaseMs int64  `json:"leaseMs,omitempty"`
+}
+
+func newHeartbeatMessage(members []string, now time.Time) heartbeatMessage {
+	leader, term, remaining := election.LeaderLease(now)
+	return heartbeatMessage{Node: nodeURL, Members: members, Leader: leader, Term: term, LeaseMs: remaining.Milliseconds()}
+}
+
+// observe aplica o que um heartbeat recebido relata.
+func (msg heartbeatMessage) observe(now time.Time) {
+	membership.Seen(msg.Node, now)
+	membership.Learn(msg.Members, now)
+	election.Observe(msg.Leader, msg.Term, time.Duration(msg.LeaseMs)*time.Millisecond, now)
+}
+
+type Membership struct {
+	mu       sync.Mutex
+	seeds    []string
+	interval time.Duration
+	timeout  time.Duration
+	// known são os nós contatados a cada heartbeat, com a última vez que foram citados.
+	known    map[string]time.Time
+	lastSeen map[string]time.Time
+}
+
+func newMembership(seeds []string, interval, timeout time.Duration) *Membership {
+	m := &Membership{
+		seeds:    seeds,
+		interval: interval,
+		timeout:  timeout,
+		known:    make(map[string]time.Time),
+		lastSeen: make(map[string]time.Time),
+	}
+	for _, seed := range seeds {
+		m.known[seed] = time.Time{}
+	}
+	return m
+}
+
+func splitNodes(list string) []string {
+	var out []string
+	for _, node := range strings.Split(list, ",") {
+		if node = strings.TrimRight(strings.TrimSpace(node), "/"); node != "" {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// Members devolve os nós vivos, incluindo este, em ordem.
+func (m *Membership) Members() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.members(time.Now())
+}
+
+// Seeds é o conjunto fixo de nós em CLUSTER_SEEDS, que precisa ser igual em todos os nós.
+// A maioria da eleição e o dono de cada correlationId são calculados sobre ele, e não sobre
+// a visão de cada nó, para que todos contem o mesmo denominador e concordem sobre o dono
+// mesmo quando nós entram, saem ou enxergam o cluster de formas diferentes.
+func (m *Membership) Seeds() []string {
+	return m.seeds
+}
+
+// Nodes são os nós que guardam pagamentos: os seeds, vivos ou não, mais os vivos. Um seed
+// morto entra para aparecer como falha nas agregações em vez de sumir da conta.
+func (m *Membership) Nodes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return mergeNodes(m.seeds, m.members(time.Now()))
+}
+
+func (m *Membership) members(now time.Time) []string {
+	var out []string
+	if nodeURL != "" {
+		out = append(out, nodeURL)
+	}
+	for node, seen := range m.lastSeen {
+		if node != nodeURL && now.Sub(seen) < m.timeout {
+			out = append(out, node)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Seen registra um contato direto com node.
+func (m *Membership) Seen(node string, now time.Time) {
+	if node == "" || node == nodeURL {
+		return
+	}
+	m.mu.Lock()
+	seen, ok := m.lastSeen[node]
+	m.lastSeen[node] = now
+	m.known[node] = now
+	m.mu.Unlock()
+
+	if !ok || now.Sub(seen) >= m.timeout {
+		fmt.Printf("[%s][MEMBERSHIP] %s joined\n", formatDate(now.UTC()), node)
+	}
+}
+
+// Learn anota nós citados por outro nó para serem contatados no próximo heartbeat.
+func (m *Membership) Learn(nodes []string, now time.Time) {
+	m.mu.Lock()
+	for _, node := range nodes {
+		if node != "" && node != nodeURL {
+			m.known[node] = now
+		}
+	}
+	m.mu.Unlock()
+}
+
+func (m *Membership) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.heartbeat(time.Now())
+		<-ticker.C
+	}
+}
+
+func (m *Membership) heartbeat(now time.Time) {
+	m.mu.Lock()
+	m.expire(now)
+	members := m.members(now)
+	targets := make([]string, 0, len(m.known))
+	for node := range m.known {
+		if node != nodeURL {
+			targets = append(targets, node)
+		}
+	}
+	m.mu.Unlock()
+	body, _ := json.Marshal(newHeartbeatMessage(members, now))
+
+	var wg sync.WaitGroup
+	for _, node := range targets {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			status, _, respBody, err := sendToPeer("POST", node, "/internal/cluster/heartbeat", nil, body)
+			if err != nil || status != fiber.StatusOK {
+				return
+			}
+			var resp heartbeatMessage
+			if err := json.Unmarshal(respBody, &resp); err != nil {
+				return
+			}
+			resp.Node = node
+			resp.observe(time.Now())
+		}(node)
+	}
+	wg.Wait()
+}
+
+// expire tira da lista quem parou de responder e esquece nós que não são seeds e não são
+// citados por ninguém há bastante tempo.
+func (m *Membership) expire(now time.Time) {
+	for node, seen := range m.lastSeen {
+		if now.Sub(seen) >= m.timeout {
+			delete(m.lastSeen, node)
+			fmt.Printf("[%s][MEMBERSHIP] %s left\n", formatDate(now.UTC()), node)
+		}
+	}
+	for node, mentioned := range m.known {
+		if !mentioned.IsZero() && now.Sub(mentioned) >= 10*m.timeout {
+			if _, alive := m.lastSeen[node]; !alive && !m.isSeed(node) {
+				delete(m.known, node)
+			}
+		}
+	}
+}
+
+func (m *Membership) isSeed(node string) bool {
+	for _, seed := range m.seeds {
+		if seed == node {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeNodes(a, b []string) []string {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, node := range append(append([]string{}, a...), b...) {
+		set[node] = struct{}{}
+	}
+	out := make([]string, 0, len(set))
+	for node := range set {
+		out = append(out, node)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func heartbeatHandler(c *fiber.Ctx) error {
+	var msg heartbeatMessage
+	if err := json.Unmarshal(c.Body(), &msg); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	now := time.Now()
+	msg.observe(now)
+	return c.Status(fiber.StatusOK).JSON(newHeartbeatMessage(membership.Members(), now))
+}
+
+func membersHandler(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"node":    nodeURL,
+		"members": membership.Members(),
+		"seeds":   membership.Seeds(),
+		"nodes":   membership.Nodes(),
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Os nós que não lideram juntam as amostras de latência dos seus workers e mandam em lote
+// ao líder a cada METRICS_PUSH_MS, para que o controlador decida com o tráfego do cluster
+// inteiro. O lote é binário: um byte de versão, a quantidade de amostras e, para cada uma,
+// processador e duração em ms (uvarint) e um byte de flags. Se o lote passar de
+// METRICS_BATCH_BYTES antes de ser enviado, as amostras seguintes são descartadas.
+const (
+	metricsBatchVersion = 1
+	metricFlagFailed    = 1 << 0
+	metricFlagProbe     = 1 << 1
+
+	metricsContentType = "application/x-rinha-metrics"
+)
+
+var metricsOutbox = newMetricsBatcher(getenvInt("METRICS_BATCH_BYTES", 64*1024))
+
+var errInvalidMetricsBatch = errors.New("lote de métricas inválido")
+
+type MetricsBatcher struct {
+	mu       sync.Mutex
+	buf      []byte
+	count    int
+	maxBytes int
+	dropped  int
+}
+
+func newMetricsBatcher(maxBytes int) *MetricsBatcher {
+	return &MetricsBatcher{maxBytes: maxBytes}
+}
+
+func (b *MetricsBatcher) Add(m Metric) {
+	var flags byte
+	if m.Failed {
+		flags |= metricFlagFailed
+	}
+	if m.Probe {
+		flags |= metricFlagProbe
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.buf)+2*binary.MaxVarintLen64+1 > b.maxBytes {
+		b.dropped++
+		return
+	}
+	b.buf = binary.AppendUvarint(b.buf, uint64(m.Processor))
+	b.buf = binary.AppendUvarint(b.buf, uint64(max(m.DurationMs, 0)))
+	b.buf = append(b.buf, flags)
+	b.count++
+}
+
+// take devolve o lote codificado e recomeça um vazio; nil se não há amostras.
+func (b *MetricsBatcher) take() ([]byte, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dropped := b.dropped
+	b.dropped = 0
+	if b.count == 0 {
+		return nil, dropped
+	}
+	out := make([]byte, 0, len(b.buf)+binary.MaxVarintLen64+1)
+	out = append(out, metricsBatchVersion)
+	out = binary.AppendUvarint(out, uint64(b.count))
+	out = append(out, b.buf...)
+	b.buf = b.buf[:0]
+	b.count = 0
+	return out, dropped
+}
+
+func decodeMetricsBatch(data []byte) ([]Metric, error) {
+	if len(data) < 1 || data[0] != metricsBatchVersion {
+		return nil, errInvalidMetricsBatch
+	}
+	data = data[1:]
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, errInvalidMetricsBatch
+	}
+	data = data[n:]
+
+	out := make([]Metric, 0, count)
+	for range count {
+		processor, n := binary.Uvarint(data)
+		if n <= 0 || processor >= uint64(len(processors)) {
+			return nil, errInvalidMetricsBatch
+		}
+		data = data[n:]
+		duration, n := binary.Uvarint(data)
+		if n <= 0 || len(data) < n+1 {
+			return nil, errInvalidMetricsBatch
+		}
+		flags := data[n]
+		data = data[n+1:]
+
+		out = append(out, Metric{
+			Processor:  int(processor),
+			DurationMs: int64(duration),
+			Failed:     flags&metricFlagFailed != 0,
+			Probe:      flags&metricFlagProbe != 0,
+		})
+	}
+	return out, nil
+}
+
+// reportMetric encaminha uma amostra local: no líder vai direto para a janela do
+// controlador, nos demais nós entra no próximo lote.
+func reportMetric(m Metric) {
+	if election.Leading() {
+		observeClusterMetric(m)
+		return
+	}
+	metricsOutbox.Add(m)
+}
+
+// observeClusterMetric junta uma amostra, local ou de outro nó, à janela do controlador.
+func observeClusterMetric(m Metric) {
+	processors[m.Processor].clusterLatency.Record(m.DurationMs, m.Failed)
+	if m.Probe {
+		select {
+		case metricsChan <- m:
+		default:
+		}
+	}
+}
+
+func pushMetrics() {
+	interval := getenvDurationMS("METRICS_PUSH_MS", 500)
+	for {
+		time.Sleep(interval)
+
+		batch, dropped := metricsOutbox.take()
+		if dropped > 0 {
+			fmt.Printf("[%s][METRICS] Dropped %d samples, batch full\n", getUTCNowFormatted(), dropped)
+		}
+		leader := election.Leader()
+		if batch == nil || leader == "" || leader == nodeURL {
+			continue
+		}
+
+		header := http.Header{}
+		header.Set(fiber.HeaderContentType, metricsContentType)
+		status, _, _, err := sendToPeer("POST", leader, "/internal/cluster/metrics", header, batch)
+		if err != nil || status != fiber.StatusNoContent {
+			fmt.Printf("Erro ao enviar métricas para %s: status %d, %v\n", leader, status, err)
+		}
+	}
+}
+
+func receiveMetricsHandler(c *fiber.Ctx) error {
+	metrics, err := decodeMetricsBatch(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	for _, m := range metrics {
+		observeClusterMetric(m)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Money guarda valores em centavos para que somas e agregações sejam exatas.
+type Money int64
+
+var errInvalidAmount = errors.New("amount inválido: precisa ser um número com no máximo 2 casas decimais")
+
+// maxMoneyDigits limita a parte inteira para que o valor em centavos caiba em int64.
+const maxMoneyDigits = 15
+
+// parseMoney aceita só dígitos com no máximo 2 casas decimais ("10", "10.5", "-0.01"), sem
+// expoente, e confere o tamanho antes de converter, então nenhum literal custa mais que um
+// ParseInt.
+func parseMoney(s string) (Money, error) {
+	digits := strings.TrimPrefix(s, "-")
+	whole, frac, hasFrac := strings.Cut(digits, ".")
+	if !isDigits(whole) || len(whole) > maxMoneyDigits || (hasFrac && (!isDigits(frac) || len(frac) > 2)) {
+		return 0, errInvalidAmount
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	cents, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errInvalidAmount
+	}
+	if len(digits) < len(s) {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// moneyFormParser faz o BodyParser ler amount de formulários como texto decimal, igual ao
+// JSON; sem ele o decoder de formulários trataria Money como int64 e "10" viraria 10 centavos.
+var moneyFormParser = fiber.ParserType{
+	Customtype: Money(0),
+	Converter: func(value string) reflect.Value {
+		m, err := parseMoney(value)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(m)
+	},
+}
+
+func (m Money) String() string {
+	cents := int64(m)
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+	}
+	abs := uint64(cents)
+	if cents < 0 {
+		abs = uint64(-cents)
+	}
+	frac := strconv.FormatUint(abs%100, 10)
+	if len(frac) == 1 {
+		frac = "0" + frac
+	}
+	return sign + strconv.FormatUint(abs/100, 10) + "." + frac
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return errInvalidAmount
+	}
+	v, err := parseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"10", 1000, true},
+		{"10.5", 1050, true},
+		{"10.50", 1050, true},
+		{"0.01", 1, true},
+		{"-0.01", -1, true},
+		{"123456789012345.99", 12345678901234599, true},
+		{"10.555", 0, false},
+		{"10.", 0, false},
+		{".5", 0, false},
+		{"1e2", 0, false},
+		{"1e1000000", 0, false},
+		{"1234567890123456", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+		{"+1", 0, false},
+		{"1,5", 0, false},
+		{"NaN", 0, false},
+	}
+	for _, c := range cases {
+		got, err := parseMoney(c.in)
+		if c.ok && (err != nil || got != c.want) {
+			t.Errorf("parseMoney(%q) = %d, %v; want %d", c.in, got, err, c.want)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("parseMoney(%q) = %d; want error", c.in, got)
+		}
+	}
+}
+
+func TestParseMoneyRejectsHugeLiteralQuickly(t *testing.T) {
+	start := time.Now()
+	if _, err := parseMoney("1" + strings.Repeat("0", 1_000_000)); err == nil {
+		t.Fatal("literal gigante aceito")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Fatalf("parseMoney levou %v", elapsed)
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	for _, m := range []Money{0, 1, 10, 1050, 100000, -1, -250} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil || back != m {
+			t.Fatalf("%s voltou como %d (%v), want %d", data, back, err, m)
+		}
+	}
+
+	var p PaymentRequest
+	if err := json.Unmarshal([]byte(`{"amount":"10.00"}`), &p); err == nil {
+		t.Fatal("amount como string aceito")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultProcessors = "default=http://payment-processor-default:8080,fallback=http://payment-processor-fallback:8080"
+
+// Processor é um processador de pagamentos configurado. A posição no registro (ordenado por
+// prioridade) é o valor de RouteDecision que aponta para ele, então todos os nós precisam
+// da mesma configuração.
+type Processor struct {
+	Index      int
+	Name       string
+	URL        string
+	FeeRate    float64
+	Priority   int
+	Timeout    time.Duration
+	MaxLatency int64
+
+	client *http.Client
+	// latency guarda o que os workers deste nó observaram; clusterLatency, usado pelo
+	// controlador, soma as amostras de todos os nós quando este é o líder.
+	latency        *LatencyHistogram
+	clusterLatency *LatencyHistogram
+}
+
+var processors = loadProcessors()
+
+// loadProcessors lê PROCESSORS ("nome=url,nome=url,...") e, para cada processador,
+// PROCESSOR_<NOME>_FEE_BPS, PROCESSOR_<NOME>_PRIORITY, PROCESSOR_<NOME>_TIMEOUT_MS e
+// PROCESSOR_<NOME>_MAX_LATENCY. Os dois primeiros herdam DEFAULT_FEE_BPS/FALLBACK_FEE_BPS e
+// MAX_DEFAULT_LATENCY/MAX_FALLBACK_LATENCY. O timeout padrão é o dobro da latência máxima,
+// como era o do antigo workerClient: um processador travado não pode prender o worker.
+// LATENCY_WINDOW_MS e LATENCY_WINDOW_SLOTS definem a janela dos histogramas de latência.
+func loadProcessors() []*Processor {
+	window := getenvDurationMS("LATENCY_WINDOW_MS", 5000)
+	slots := getenvInt("LATENCY_WINDOW_SLOTS", 10)
+
+	var out []*Processor
+	for i, entry := range strings.Split(getenvString("PROCESSORS", defaultProcessors), ",") {
+		name, url, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || url == "" {
+			log.Fatalf("PROCESSORS inválido: %q", entry)
+		}
+
+		key := "PROCESSOR_" + strings.ToUpper(name) + "_"
+		feeBPS, maxLatency := getenvInt("FALLBACK_FEE_BPS", 1500), getenvInt64("MAX_FALLBACK_LATENCY", 100)
+		if i == 0 {
+			feeBPS, maxLatency = getenvInt("DEFAULT_FEE_BPS", 500), getenvInt64("MAX_DEFAULT_LATENCY", 100)
+		}
+		maxLatency = getenvInt64(key+"MAX_LATENCY", maxLatency)
+		timeout := getenvDurationMS(key+"TIMEOUT_MS", 2*int(maxLatency))
+
+		out = append(out, &Processor{
+			Name:           name,
+			URL:            strings.TrimRight(url, "/"),
+			FeeRate:        float64(getenvInt(key+"FEE_BPS", feeBPS)) / 10000,
+			Priority:       getenvInt(key+"PRIORITY", i),
+			Timeout:        timeout,
+			MaxLatency:     maxLatency,
+			client:         &http.Client{Timeout: timeout},
+			latency:        newLatencyHistogram(window, slots),
+			clusterLatency: newLatencyHistogram(window, slots),
+		})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Priority < out[j].Priority })
+	for i, p := range out {
+		p.Index = i
+	}
+	return out
+}
+
+// processorFor devolve o processador apontado por uma decisão de roteamento, ou nil para RouteHold.
+func processorFor(route RouteDecision) *Processor {
+	if route < 0 || int(route) >= len(processors) {
+		return nil
+	}
+	return processors[route]
+}
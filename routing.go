@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RouteDecision é o valor guardado em circuitStatusFlag: o índice do processador no
+// registro (0 é o de maior prioridade) ou RouteHold.
+type RouteDecision int32
+
+const (
+	RouteDefault RouteDecision = 0  // processador de maior prioridade
+	RouteHold    RouteDecision = -1 // circuito aberto: segura os pagamentos
+)
+
+func (d RouteDecision) String() string {
+	if d == RouteHold {
+		return "hold"
+	}
+	if p := processorFor(d); p != nil {
+		return p.Name
+	}
+	return fmt.Sprintf("unknown(%d)", int32(d))
+}
+
+type ProcessorSnapshot struct {
+	Index      int
+	Name       string
+	FeeRate    float64
+	MaxLatency int64
+	Health     ServiceHealth
+	// P95 das latências observadas na janela, em ms; 0 quando não há amostras.
+	P95 int64
+	// SuccessRate é a fração de envios bem-sucedidos na janela (1 sem amostras).
+	SuccessRate float64
+}
+
+func (s ProcessorSnapshot) withinLatency() bool {
+	return s.P95 <= s.MaxLatency
+}
+
+type RoutingInput struct {
+	Now time.Time
+	// Processors vem na ordem do registro, ou seja, por prioridade.
+	Processors []ProcessorSnapshot
+	// QueueDepth conta os pagamentos na fila e os retidos no retryScheduler.
+	QueueDepth int
+}
+
+// RoutingPolicy decide para onde os workers mandam os pagamentos. Implementações podem
+// guardar estado entre chamadas, mas não fazem I/O: recebem tudo em RoutingInput.
+type RoutingPolicy interface {
+	Name() string
+	Decide(in RoutingInput) RouteDecision
+	// Fallbacks ordena, por preferência da política, os outros processadores para os quais
+	// um worker pode cair quando o envio à rota decidida falha. Com RouteHold não há nenhum.
+	Fallbacks(in RoutingInput, route RouteDecision) []RouteDecision
+}
+
+// fallbacksByPriority lista, na ordem do registro, os processadores diferentes de route
+// que passam em eligible.
+func fallbacksByPriority(in RoutingInput, route RouteDecision, eligible func(ProcessorSnapshot) bool) []RouteDecision {
+	if route == RouteHold {
+		return nil
+	}
+	var out []RouteDecision
+	for _, s := range in.Processors {
+		if RouteDecision(s.Index) != route && eligible(s) {
+			out = append(out, RouteDecision(s.Index))
+		}
+	}
+	return out
+}
+
+type routingConfig struct {
+	useFallbackAllowed bool
+	failoverDelay      time.Duration
+	preferHold         bool
+	holdQueueLimit     int
+}
+
+func loadRoutingConfig() routingConfig {
+	return routingConfig{
+		useFallbackAllowed: getenvBool("USE_FALLBACK", false),
+		failoverDelay:      getenvDurationSec("PRIMARY_FAILOVER_DELAY_SEC", 15),
+		preferHold:         getenvBool("FEE_PREFER_HOLD", true),
+		holdQueueLimit:     getenvInt("FEE_HOLD_QUEUE_LIMIT", QueueCapacity/2),
+	}
+}
+
+// routingPolicyName é validado em main, para que um nome errado derrube o nó na subida e não
+// cada líder ao ser eleito.
+var routingPolicyName = getenvString("ROUTING_POLICY", "fee")
+
+// newRoutingPolicy escolhe a política pelo nome configurado em ROUTING_POLICY; sem nome, a
+// que considera taxas e taxas de sucesso.
+func newRoutingPolicy(name string, cfg routingConfig) (RoutingPolicy, error) {
+	switch name {
+	case "", "fee":
+		return &feeAwarePolicy{cfg: cfg}, nil
+	case "latency":
+		return &latencyFailoverPolicy{cfg: cfg}, nil
+	case "health":
+		return &healthPolicy{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("ROUTING_POLICY desconhecida: %q", name)
+}
+
+// latencyFailoverPolicy é o comportamento original do circuitController: sai do principal
+// quando o p95 passa do limite de latência ou o health check acusa falha, e só vai para o
+// próximo processador saudável depois de PRIMARY_FAILOVER_DELAY_SEC seguidos nessa situação.
+type latencyFailoverPolicy struct {
+	cfg                   routingConfig
+	fallbackEligibleSince time.Time
+}
+
+func (p *latencyFailoverPolicy) Name() string { return "latency" }
+
+func (p *latencyFailoverPolicy) Decide(in RoutingInput) RouteDecision {
+	if len(in.Processors) == 0 {
+		return RouteHold
+	}
+
+	allFailing := true
+	for _, s := range in.Processors {
+		allFailing = allFailing && s.Health.Failing
+	}
+	primary := in.Processors[0]
+	if allFailing || (primary.withinLatency() && !primary.Health.Failing) {
+		p.fallbackEligibleSince = time.Time{}
+		return RouteDefault
+	}
+
+	var candidate *ProcessorSnapshot
+	if p.cfg.useFallbackAllowed {
+		for i := 1; i < len(in.Processors); i++ {
+			if !in.Processors[i].Health.Failing {
+				candidate = &in.Processors[i]
+				break
+			}
+		}
+	}
+	if candidate == nil {
+		p.fallbackEligibleSince = time.Time{}
+		return RouteHold
+	}
+
+	if p.fallbackEligibleSince.IsZero() {
+		p.fallbackEligibleSince = in.Now
+	}
+	if in.Now.Sub(p.fallbackEligibleSince) < p.cfg.failoverDelay {
+		return RouteHold
+	}
+	if !candidate.withinLatency() {
+		p.fallbackEligibleSince = time.Time{}
+		return RouteHold
+	}
+	return RouteDecision(candidate.Index)
+}
+
+// Fallbacks só inclui outros processadores com USE_FALLBACK, e só os saudáveis e dentro do
+// limite de latência.
+func (p *latencyFailoverPolicy) Fallbacks(in RoutingInput, route RouteDecision) []RouteDecision {
+	if !p.cfg.useFallbackAllowed {
+		return nil
+	}
+	return fallbacksByPriority(in, route, func(s ProcessorSnapshot) bool {
+		return !s.Health.Failing && s.withinLatency()
+	})
+}
+
+// healthPolicy olha apenas o health check: o primeiro processador saudável por prioridade.
+type healthPolicy struct {
+	cfg routingConfig
+}
+
+func (p *healthPolicy) Name() string { return "health" }
+
+func (p *healthPolicy) Decide(in RoutingInput) RouteDecision {
+	for i, s := range in.Processors {
+		if i > 0 && !p.cfg.useFallbackAllowed {
+			break
+		}
+		if !s.Health.Failing {
+			return RouteDecision(s.Index)
+		}
+	}
+	return RouteHold
+}
+
+func (p *healthPolicy) Fallbacks(in RoutingInput, route RouteDecision) []RouteDecision {
+	if !p.cfg.useFallbackAllowed {
+		return nil
+	}
+	return fallbacksByPriority(in, route, func(s ProcessorSnapshot) bool { return !s.Health.Failing })
+}
+
+// feeAwarePolicy escolhe o processador com o maior lucro líquido esperado por real enviado,
+// P(sucesso) × (1 - taxa). Um p95 acima do limite de latência reduz a probabilidade de
+// sucesso na proporção do excesso, já que esses envios tendem a estourar o timeout do worker.
+// Com FEE_PREFER_HOLD, se o melhor destino não for o principal mas a fila ainda comporta
+// espera (até FEE_HOLD_QUEUE_LIMIT), os pagamentos ficam retidos aguardando o principal.
+type feeAwarePolicy struct {
+	cfg routingConfig
+}
+
+// minExpectedProfit abaixo disso nenhum processador compensa e os pagamentos ficam retidos.
+const minExpectedProfit = 0.05
+
+func (p *feeAwarePolicy) Name() string { return "fee" }
+
+func (p *feeAwarePolicy) Decide(in RoutingInput) RouteDecision {
+	best, bestEV := RouteHold, 0.0
+	for i, s := range in.Processors {
+		if i > 0 && !p.cfg.useFallbackAllowed {
+			break
+		}
+		if ev := expectedProfit(s); ev > bestEV {
+			best, bestEV = RouteDecision(s.Index), ev
+		}
+	}
+
+	if bestEV < minExpectedProfit {
+		return RouteHold
+	}
+	if best != RouteDefault && p.cfg.preferHold && in.QueueDepth < p.cfg.holdQueueLimit {
+		return RouteHold
+	}
+	return best
+}
+
+// Fallbacks ordena os outros processadores pelo lucro esperado, descartando os que ficam
+// abaixo de minExpectedProfit.
+func (p *feeAwarePolicy) Fallbacks(in RoutingInput, route RouteDecision) []RouteDecision {
+	if !p.cfg.useFallbackAllowed {
+		return nil
+	}
+	out := fallbacksByPriority(in, route, func(s ProcessorSnapshot) bool {
+		return expectedProfit(s) >= minExpectedProfit
+	})
+	sort.SliceStable(out, func(i, j int) bool {
+		return expectedProfit(in.Processors[out[i]]) > expectedProfit(in.Processors[out[j]])
+	})
+	return out
+}
+
+func expectedProfit(s ProcessorSnapshot) float64 {
+	if s.Health.Failing {
+		return 0
+	}
+	success := s.SuccessRate
+	if s.MaxLatency > 0 && s.P95 > s.MaxLatency {
+		success *= float64(s.MaxLatency) / float64(s.P95)
+	}
+	return success * (1 - s.FeeRate)
+}
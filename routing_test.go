@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// legacyController é a decisão que o circuitController fazia antes das políticas, com dois
+// processadores (0 principal, 1 fallback, 2 aberto).
+type legacyController struct {
+	useFallbackAllowed    bool
+	maxDefaultLatency     int64
+	maxFallbackLatency    int64
+	failoverDelay         time.Duration
+	fallbackEligibleSince time.Time
+}
+
+func (l *legacyController) decide(now time.Time, primaryHealth, fallbackHealth ServiceHealth, p95Primary, p95Fallback int64) int {
+	status := 0
+
+	if primaryHealth.Failing && fallbackHealth.Failing {
+		l.fallbackEligibleSince = time.Time{}
+		return status
+	}
+	if (p95Primary > 0 && p95Primary > l.maxDefaultLatency) || primaryHealth.Failing {
+		if l.useFallbackAllowed && !fallbackHealth.Failing {
+			if l.fallbackEligibleSince.IsZero() {
+				l.fallbackEligibleSince = now
+			}
+			if now.Sub(l.fallbackEligibleSince) >= l.failoverDelay {
+				status = 1
+			} else {
+				status = 2
+			}
+		} else {
+			status = 2
+			l.fallbackEligibleSince = time.Time{}
+		}
+	} else {
+		l.fallbackEligibleSince = time.Time{}
+	}
+
+	if status == 1 && p95Fallback > 0 && p95Fallback > l.maxFallbackLatency {
+		status = 2
+		l.fallbackEligibleSince = time.Time{}
+	}
+	return status
+}
+
+func TestLatencyPolicyMatchesLegacyController(t *testing.T) {
+	for _, useFallback := range []bool{false, true} {
+		rng := rand.New(rand.NewSource(7))
+		legacy := &legacyController{
+			useFallbackAllowed: useFallback,
+			maxDefaultLatency:  100,
+			maxFallbackLatency: 80,
+			failoverDelay:      15 * time.Second,
+		}
+		policy := &latencyFailoverPolicy{cfg: routingConfig{useFallbackAllowed: useFallback, failoverDelay: 15 * time.Second}}
+
+		now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 20000; i++ {
+			// Passos de 5s com estados que duram alguns ciclos, para o atraso de failover vencer às vezes.
+			now = now.Add(5 * time.Second)
+			primary := ServiceHealth{Failing: rng.Intn(4) == 0}
+			fallback := ServiceHealth{Failing: rng.Intn(5) == 0}
+			p95Primary, p95Fallback := int64(rng.Intn(200)), int64(rng.Intn(160))
+			if rng.Intn(3) == 0 {
+				p95Primary = 0
+			}
+
+			want := legacy.decide(now, primary, fallback, p95Primary, p95Fallback)
+			got := policy.Decide(RoutingInput{Now: now, Processors: []ProcessorSnapshot{
+				{Index: 0, Name: "default", MaxLatency: 100, Health: primary, P95: p95Primary},
+				{Index: 1, Name: "fallback", MaxLatency: 80, Health: fallback, P95: p95Fallback},
+			}})
+
+			wantRoute := map[int]RouteDecision{0: RouteDefault, 1: 1, 2: RouteHold}[want]
+			if got != wantRoute {
+				t.Fatalf("useFallback=%v passo %d: primary=%+v/%d fallback=%+v/%d: got %v, want %d",
+					useFallback, i, primary, p95Primary, fallback, p95Fallback, int32(got), want)
+			}
+		}
+	}
+}
+
+func snapshots(specs ...ProcessorSnapshot) []ProcessorSnapshot {
+	for i := range specs {
+		specs[i].Index = i
+		specs[i].Name = fmt.Sprintf("p%d", i)
+		if specs[i].MaxLatency == 0 {
+			specs[i].MaxLatency = 100
+		}
+		if specs[i].SuccessRate == 0 {
+			specs[i].SuccessRate = 1
+		}
+	}
+	return specs
+}
+
+var failing = ServiceHealth{Failing: true}
+
+func TestRoutingPolicies(t *testing.T) {
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	withFallback := routingConfig{useFallbackAllowed: true, failoverDelay: 15 * time.Second, holdQueueLimit: 100}
+
+	cases := []struct {
+		name          string
+		policy        RoutingPolicy
+		in            RoutingInput
+		want          RouteDecision
+		wantFallbacks []RouteDecision
+	}{
+		{
+			name:   "latency sem processadores",
+			policy: &latencyFailoverPolicy{cfg: withFallback},
+			in:     RoutingInput{Now: now},
+			want:   RouteHold,
+		},
+		{
+			name:          "latency principal ok",
+			policy:        &latencyFailoverPolicy{cfg: withFallback},
+			in:            RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{P95: 50}, ProcessorSnapshot{}, ProcessorSnapshot{P95: 300})},
+			want:          RouteDefault,
+			wantFallbacks: []RouteDecision{1},
+		},
+		{
+			name:   "latency principal lento segura durante o atraso",
+			policy: &latencyFailoverPolicy{cfg: withFallback},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{P95: 150}, ProcessorSnapshot{})},
+			want:   RouteHold,
+		},
+		{
+			name:          "latency depois do atraso pula o fallback com falha",
+			policy:        &latencyFailoverPolicy{cfg: withFallback, fallbackEligibleSince: now.Add(-time.Minute)},
+			in:            RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{Health: failing}, ProcessorSnapshot{})},
+			want:          2,
+			wantFallbacks: nil,
+		},
+		{
+			name:   "latency sem USE_FALLBACK",
+			policy: &latencyFailoverPolicy{fallbackEligibleSince: now.Add(-time.Minute)},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{})},
+			want:   RouteHold,
+		},
+		{
+			name:   "latency todos com falha fica no principal",
+			policy: &latencyFailoverPolicy{cfg: withFallback},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{Health: failing})},
+			want:   RouteDefault,
+		},
+		{
+			name:          "health primeiro saudável",
+			policy:        &healthPolicy{cfg: withFallback},
+			in:            RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{P95: 500}, ProcessorSnapshot{})},
+			want:          1,
+			wantFallbacks: []RouteDecision{2},
+		},
+		{
+			name:   "health sem USE_FALLBACK",
+			policy: &healthPolicy{},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{})},
+			want:   RouteHold,
+		},
+		{
+			name:   "health todos com falha",
+			policy: &healthPolicy{cfg: withFallback},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{Health: failing})},
+			want:   RouteHold,
+		},
+		{
+			name:          "fee principal mais barato",
+			policy:        &feeAwarePolicy{cfg: withFallback},
+			in:            RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{FeeRate: 0.05}, ProcessorSnapshot{FeeRate: 0.15}, ProcessorSnapshot{FeeRate: 0.10})},
+			want:          RouteDefault,
+			wantFallbacks: []RouteDecision{2, 1},
+		},
+		{
+			name:   "fee prefere segurar com fila curta",
+			policy: &feeAwarePolicy{cfg: routingConfig{useFallbackAllowed: true, preferHold: true, holdQueueLimit: 100}},
+			in:     RoutingInput{Now: now, QueueDepth: 10, Processors: snapshots(ProcessorSnapshot{FeeRate: 0.05, SuccessRate: 0.5}, ProcessorSnapshot{FeeRate: 0.15})},
+			want:   RouteHold,
+		},
+		{
+			name:          "fee cai para o fallback com fila cheia",
+			policy:        &feeAwarePolicy{cfg: routingConfig{useFallbackAllowed: true, preferHold: true, holdQueueLimit: 100}},
+			in:            RoutingInput{Now: now, QueueDepth: 100, Processors: snapshots(ProcessorSnapshot{FeeRate: 0.05, SuccessRate: 0.5}, ProcessorSnapshot{FeeRate: 0.15})},
+			want:          1,
+			wantFallbacks: []RouteDecision{RouteDefault},
+		},
+		{
+			name:          "fee penaliza p95 acima do limite",
+			policy:        &feeAwarePolicy{cfg: withFallback},
+			in:            RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{FeeRate: 0.05, P95: 400}, ProcessorSnapshot{FeeRate: 0.15})},
+			want:          1,
+			wantFallbacks: []RouteDecision{RouteDefault},
+		},
+		{
+			name:   "fee nada compensa",
+			policy: &feeAwarePolicy{cfg: withFallback},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{SuccessRate: 0.04}, ProcessorSnapshot{Health: failing})},
+			want:   RouteHold,
+		},
+		{
+			name:   "fee sem USE_FALLBACK ignora os outros",
+			policy: &feeAwarePolicy{},
+			in:     RoutingInput{Now: now, Processors: snapshots(ProcessorSnapshot{Health: failing}, ProcessorSnapshot{})},
+			want:   RouteHold,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.policy.Decide(c.in)
+			if got != c.want {
+				t.Fatalf("Decide = %d, want %d", int32(got), int32(c.want))
+			}
+			fallbacks := c.policy.Fallbacks(c.in, got)
+			if fmt.Sprint(fallbacks) != fmt.Sprint(c.wantFallbacks) {
+				t.Fatalf("Fallbacks = %d, want %d", fallbacks, c.wantFallbacks)
+			}
+		})
+	}
+}
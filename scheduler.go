@@ -0,0 +1,186 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// retryEnqueueDelay é quanto o scheduler espera antes de tentar de novo quando a fila está cheia.
+const retryEnqueueDelay = 20 * time.Millisecond
+
+// retryScheduler segura os pagamentos fora da fila principal: os que aguardam backoff ficam
+// num min-heap ordenado pela próxima tentativa e os que aguardam o circuito fechar ficam
+// estacionados até circuitStatusFlag deixar de ser RouteHold. Ele nunca bloqueia enviando para a fila.
+var retryScheduler = newRetryScheduler()
+
+type scheduledPayment struct {
+	p  *PaymentRequest
+	at time.Time
+}
+
+type retryHeap []scheduledPayment
+
+func (h retryHeap) Len() int           { return len(h) }
+func (h retryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h retryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x any)        { *h = append(*h, x.(scheduledPayment)) }
+func (h *retryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = scheduledPayment{}
+	*h = old[:n-1]
+	return item
+}
+
+type RetryScheduler struct {
+	mu      sync.Mutex
+	delays  retryHeap
+	parked  []*PaymentRequest
+	wake    chan struct{}
+	stopped bool
+}
+
+func newRetryScheduler() *RetryScheduler {
+	return &RetryScheduler{wake: make(chan struct{}, 1)}
+}
+
+// Schedule devolve p à fila quando at chegar.
+func (s *RetryScheduler) Schedule(p *PaymentRequest, at time.Time) {
+	s.mu.Lock()
+	heap.Push(&s.delays, scheduledPayment{p: p, at: at})
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Park segura p até o circuito deixar de estar aberto.
+func (s *RetryScheduler) Park(p *PaymentRequest) {
+	s.mu.Lock()
+	s.parked = append(s.parked, p)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// CircuitChanged acorda o scheduler para liberar os pagamentos estacionados.
+func (s *RetryScheduler) CircuitChanged() {
+	s.notify()
+}
+
+func (s *RetryScheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.delays) + len(s.parked)
+}
+
+// Stop impede novas liberações para a fila; o que já está ou ainda for agendado fica retido até Drain.
+func (s *RetryScheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+}
+
+// Drain devolve e esquece todos os pagamentos retidos.
+func (s *RetryScheduler) Drain() []*PaymentRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*PaymentRequest, 0, len(s.delays)+len(s.parked))
+	for _, item := range s.delays {
+		out = append(out, item.p)
+	}
+	out = append(out, s.parked...)
+	s.delays = nil
+	s.parked = nil
+	return out
+}
+
+func (s *RetryScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *RetryScheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := s.release(time.Now())
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// release envia à fila tudo que já pode sair e devolve quanto esperar até a próxima liberação.
+func (s *RetryScheduler) release(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := time.Hour
+	if s.stopped {
+		return wait
+	}
+
+	for len(s.delays) > 0 && !s.delays[0].at.After(now) {
+		if !tryEnqueue(s.delays[0].p) {
+			s.delays[0].at = now.Add(retryEnqueueDelay)
+			heap.Fix(&s.delays, 0)
+			break
+		}
+		heap.Pop(&s.delays)
+	}
+	if len(s.delays) > 0 {
+		wait = s.delays[0].at.Sub(now)
+	}
+
+	if len(s.parked) > 0 {
+		// Com o circuito aberto só sai um pagamento por vez, para servir de sonda ao breaker.
+		limit := len(s.parked)
+		if RouteDecision(atomic.LoadInt32(&circuitStatusFlag)) == RouteHold && !breaker.Accepting() {
+			limit = 0
+			probe, untilHalfOpen := breaker.WantsProbe(now)
+			if probe {
+				limit = 1
+			}
+			wait = min(wait, max(untilHalfOpen, retryEnqueueDelay))
+		}
+
+		released := 0
+		for _, p := range s.parked[:limit] {
+			if !tryEnqueue(p) {
+				break
+			}
+			released++
+		}
+		clear(s.parked[:released])
+		s.parked = s.parked[released:]
+		if released < limit {
+			wait = min(wait, retryEnqueueDelay)
+		}
+	}
+
+	return max(wait, 0)
+}
+
+func tryEnqueue(p *PaymentRequest) bool {
+	select {
+	case queue <- p:
+		return true
+	default:
+		return false
+	}
+}
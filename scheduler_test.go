@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withSchedulerGlobals troca a fila, o circuito e o breaker usados por release durante o teste.
+func withSchedulerGlobals(t *testing.T, capacity int, status RouteDecision) chan *PaymentRequest {
+	savedQueue, savedBreaker := queue, breaker
+	savedStatus := atomic.LoadInt32(&circuitStatusFlag)
+	t.Cleanup(func() {
+		queue, breaker = savedQueue, savedBreaker
+		atomic.StoreInt32(&circuitStatusFlag, savedStatus)
+	})
+
+	queue = make(chan *PaymentRequest, capacity)
+	breaker = newCircuitBreaker(1, time.Second, time.Second)
+	atomic.StoreInt32(&circuitStatusFlag, int32(status))
+	return queue
+}
+
+func TestRetrySchedulerReleasesInOrder(t *testing.T) {
+	q := withSchedulerGlobals(t, 2, RouteDefault)
+	s := newRetryScheduler()
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	a, b, c := &PaymentRequest{CorrelationID: "a"}, &PaymentRequest{CorrelationID: "b"}, &PaymentRequest{CorrelationID: "c"}
+	s.Schedule(c, now.Add(300*time.Millisecond))
+	s.Schedule(a, now.Add(100*time.Millisecond))
+	s.Schedule(b, now.Add(200*time.Millisecond))
+
+	if wait := s.release(now); wait != 100*time.Millisecond || len(q) != 0 {
+		t.Fatalf("antes do prazo: espera %v, %d na fila", wait, len(q))
+	}
+	if wait := s.release(now.Add(250 * time.Millisecond)); wait != 50*time.Millisecond {
+		t.Fatalf("espera %v, want 50ms", wait)
+	}
+	if got := (<-q).CorrelationID + (<-q).CorrelationID; got != "ab" {
+		t.Fatalf("liberados %s, want ab", got)
+	}
+
+	// Com a fila cheia o pagamento fica no heap e é tentado de novo em retryEnqueueDelay.
+	q <- &PaymentRequest{}
+	q <- &PaymentRequest{}
+	later := now.Add(time.Second)
+	if wait := s.release(later); wait != retryEnqueueDelay || s.Pending() != 1 {
+		t.Fatalf("fila cheia: espera %v, %d retidos", wait, s.Pending())
+	}
+	<-q
+	if s.release(later.Add(retryEnqueueDelay)); s.Pending() != 0 {
+		t.Fatalf("%d retidos depois de liberar espaço na fila", s.Pending())
+	}
+}
+
+func TestRetrySchedulerParksWhileHeld(t *testing.T) {
+	q := withSchedulerGlobals(t, 10, RouteHold)
+	s := newRetryScheduler()
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range []string{"a", "b", "c"} {
+		s.Park(&PaymentRequest{CorrelationID: id})
+	}
+
+	// Circuito aberto: nada sai durante o cooldown do breaker.
+	breaker.Route(RouteHold, now)
+	if wait := s.release(now); wait != time.Second || len(q) != 0 {
+		t.Fatalf("no cooldown: espera %v, %d na fila", wait, len(q))
+	}
+
+	// No half-open sai um só, como sonda.
+	now = now.Add(time.Second)
+	s.release(now)
+	if len(q) != 1 || s.Pending() != 2 {
+		t.Fatalf("half-open: %d na fila, %d retidos", len(q), s.Pending())
+	}
+
+	// Com o circuito fechado saem todos.
+	atomic.StoreInt32(&circuitStatusFlag, int32(RouteDefault))
+	s.release(now)
+	if len(q) != 3 || s.Pending() != 0 {
+		t.Fatalf("fechado: %d na fila, %d retidos", len(q), s.Pending())
+	}
+}
+
+func TestRetrySchedulerStopAndDrain(t *testing.T) {
+	q := withSchedulerGlobals(t, 10, RouteDefault)
+	s := newRetryScheduler()
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	s.Schedule(&PaymentRequest{CorrelationID: "a"}, now)
+	s.Stop()
+	s.Park(&PaymentRequest{CorrelationID: "b"})
+	s.release(now.Add(time.Second))
+	if len(q) != 0 {
+		t.Fatalf("%d liberados depois de Stop", len(q))
+	}
+
+	drained := s.Drain()
+	if len(drained) != 2 || s.Pending() != 0 {
+		t.Fatalf("Drain devolveu %d, %d retidos", len(drained), s.Pending())
+	}
+}
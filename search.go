@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// /payments-search procura no tracker de todos os nós, em paralelo e dentro de
+// SUMMARY_DEADLINE_MS como o resumo. Filtros opcionais: minAmount, maxAmount, processor,
+// status (um ou mais separados por vírgula), from e to. Os resultados vêm ordenados por
+// requestedAt (order=asc|desc), desempatados pelo correlationId, e from/to filtram
+// requestedAt como em /payments-summary e /payments-export. Um pagamento ainda não
+// processado não tem requestedAt: ele fica de fora quando há from/to e, na ordenação, ocupa o
+// lugar do instante em que foi aceito. Ao ser processado ele ganha um requestedAt posterior a
+// tudo que já foi listado e vai para o fim da ordem asc, então a paginação asc não pula
+// ninguém; ele só pode reaparecer lá com o novo estado. A paginação é por chave: nextCursor
+// aponta para o último item devolvido, então pagamentos novos não deslocam as páginas seguintes.
+const (
+	searchDefaultLimit = 50
+	searchMaxLimit     = 500
+)
+
+var errInvalidCursor = errors.New("cursor inválido")
+
+type searchCursor struct {
+	at time.Time
+	id string
+}
+
+func (c searchCursor) String() string {
+	raw := strconv.FormatInt(c.at.UnixNano(), 10) + "|" + c.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseSearchCursor(value string) (searchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return searchCursor{}, errInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return searchCursor{}, errInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return searchCursor{}, errInvalidCursor
+	}
+	return searchCursor{at: time.Unix(0, n).UTC(), id: id}, nil
+}
+
+type paymentSearch struct {
+	minAmount, maxAmount *Money
+	processor            string
+	states               map[paymentState]bool
+	inRange              func(time.Time) bool
+	desc                 bool
+	after                *searchCursor
+	limit                int
+}
+
+type SearchResponse struct {
+	Items      []PaymentStatus `json:"items"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+	Nodes      *SummaryNodes   `json:"nodes,omitempty"`
+}
+
+func searchKey(s PaymentStatus) searchCursor {
+	if s.RequestedAt == nil {
+		return searchCursor{at: s.AcceptedAt, id: s.CorrelationID}
+	}
+	return searchCursor{at: *s.RequestedAt, id: s.CorrelationID}
+}
+
+// before diz se a vem antes de b na ordem da busca.
+func (q paymentSearch) before(a, b searchCursor) bool {
+	if !a.at.Equal(b.at) {
+		return a.at.Before(b.at) != q.desc
+	}
+	if a.id == b.id {
+		return false
+	}
+	return (a.id < b.id) != q.desc
+}
+
+func parsePaymentSearch(c *fiber.Ctx) (paymentSearch, error) {
+	q := paymentSearch{
+		processor: c.Query("processor"),
+		desc:      c.Query("order", "asc") == "desc",
+		limit:     min(c.QueryInt("limit", searchDefaultLimit), searchMaxLimit),
+	}
+	if q.limit <= 0 {
+		return q, fmt.Errorf("limit inválido")
+	}
+
+	for name, dst := range map[string]**Money{"minAmount": &q.minAmount, "maxAmount": &q.maxAmount} {
+		if value := c.Query(name); value != "" {
+			m, err := parseMoney(value)
+			if err != nil {
+				return q, fmt.Errorf("%s inválido: %w", name, err)
+			}
+			*dst = &m
+		}
+	}
+
+	if status := c.Query("status"); status != "" {
+		q.states = make(map[paymentState]bool)
+		for _, name := range strings.Split(status, ",") {
+			state, ok := parsePaymentState(strings.TrimSpace(name))
+			if !ok {
+				return q, fmt.Errorf("status desconhecido: %q", name)
+			}
+			q.states[state] = true
+		}
+	}
+
+	inRange, err := timeRangeFilter(c)
+	if err != nil {
+		return q, err
+	}
+	q.inRange = inRange
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := parseSearchCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.after = &after
+	}
+	return q, nil
+}
+
+func (q paymentSearch) match(s *PaymentStatus) bool {
+	if q.minAmount != nil && s.Amount < *q.minAmount {
+		return false
+	}
+	if q.maxAmount != nil && s.Amount > *q.maxAmount {
+		return false
+	}
+	if q.processor != "" && s.Processor != q.processor {
+		return false
+	}
+	if q.states != nil && !q.states[s.State] {
+		return false
+	}
+	// Sem requestedAt o instante zero só passa quando não há from/to.
+	var requestedAt time.Time
+	if s.RequestedAt != nil {
+		requestedAt = *s.RequestedAt
+	}
+	if !q.inRange(requestedAt) {
+		return false
+	}
+	return q.after == nil || q.before(*q.after, searchKey(*s))
+}
+
+// page ordena items e corta no limite, preenchendo NextCursor se pode haver mais.
+func (q paymentSearch) page(items []PaymentStatus) SearchResponse {
+	sort.Slice(items, func(i, j int) bool { return q.before(searchKey(items[i]), searchKey(items[j])) })
+	resp := SearchResponse{Items: items}
+	if len(items) >= q.limit {
+		resp.Items = items[:q.limit]
+		resp.NextCursor = searchKey(resp.Items[q.limit-1]).String()
+	}
+	if resp.Items == nil {
+		resp.Items = []PaymentStatus{}
+	}
+	return resp
+}
+
+func searchPaymentsHandler(c *fiber.Ctx) error {
+	q, err := parsePaymentSearch(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	strict := c.QueryBool("strict", summaryStrict)
+
+	ctx, cancel := context.WithTimeout(context.Background(), summaryDeadline)
+	defer cancel()
+
+	nodes := &SummaryNodes{Contributed: []string{nodeURL}}
+	items := tracker.Find(q.match)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	query := string(c.Context().QueryArgs().QueryString())
+	for _, node := range membership.Nodes() {
+		if node == nodeURL {
+			continue
+		}
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			remote, err := fetchPaymentSearch(ctx, node, query)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if nodes.Failed == nil {
+					nodes.Failed = make(map[string]string)
+				}
+				nodes.Failed[node] = err.Error()
+				return
+			}
+			items = append(items, remote...)
+			nodes.Contributed = append(nodes.Contributed, node)
+		}(node)
+	}
+	wg.Wait()
+	sort.Strings(nodes.Contributed)
+
+	resp := q.page(items)
+	resp.Nodes = nodes
+	if len(nodes.Failed) > 0 && strict {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(resp)
+	}
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// searchPageHandler é a rota interna: a primeira página deste nó para a mesma busca.
+func searchPageHandler(c *fiber.Ctx) error {
+	q, err := parsePaymentSearch(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	return c.Status(fiber.StatusOK).JSON(q.page(tracker.Find(q.match)).Items)
+}
+
+func fetchPaymentSearch(ctx context.Context, node, query string) ([]PaymentStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", node+"/internal/payments-search?"+query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao montar requisição: %w", err)
+	}
+	signRequest(req, nil)
+
+	resp, err := circuitClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("erro na requisição: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("erro HTTP: status %s", resp.Status)
+	}
+
+	var items []PaymentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar JSON: %w", err)
+	}
+	return items, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// searchAll percorre todas as páginas de q em tr, chamando between entre uma página e outra.
+func searchAll(tr *PaymentTracker, q paymentSearch, between func(page int)) []string {
+	var ids []string
+	for page := 0; ; page++ {
+		resp := q.page(tr.Find(q.match))
+		for _, s := range resp.Items {
+			ids = append(ids, s.CorrelationID)
+		}
+		if resp.NextCursor == "" {
+			return ids
+		}
+		after, err := parseSearchCursor(resp.NextCursor)
+		if err != nil {
+			panic(err)
+		}
+		q.after = &after
+		between(page)
+	}
+}
+
+func TestSearchCursorIsStable(t *testing.T) {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	build := func() *PaymentTracker {
+		tr := newPaymentTracker()
+		for i := range 23 {
+			id := fmt.Sprintf("p-%02d", i)
+			// Vários pagamentos no mesmo instante, para exercitar o desempate pelo correlationId.
+			at := base.Add(time.Duration(i/3) * time.Millisecond)
+			s := &PaymentStatus{CorrelationID: id, Amount: Money(100 * (i + 1)), State: stateRetrying, AcceptedAt: at.Add(-time.Second)}
+			if i%2 == 0 {
+				s.State, s.RequestedAt = stateProcessedDefault, &at
+			} else {
+				s.AcceptedAt = at
+			}
+			tr.entries[id] = s
+		}
+		return tr
+	}
+
+	for _, desc := range []bool{false, true} {
+		tr := build()
+		q := paymentSearch{desc: desc, limit: 5, inRange: func(time.Time) bool { return true }}
+		ids := searchAll(tr, q, func(int) {})
+
+		if len(ids) != 23 {
+			t.Fatalf("desc=%v: %d itens, want 23: %v", desc, len(ids), ids)
+		}
+		for i, id := range ids {
+			want := i
+			if desc {
+				want = 22 - i
+			}
+			if id != fmt.Sprintf("p-%02d", want) {
+				t.Fatalf("desc=%v: posição %d = %s, want p-%02d", desc, i, id, want)
+			}
+		}
+	}
+
+	// Processar pagamentos entre as páginas os manda para o fim da ordem asc: nenhum é
+	// pulado e os que não mudaram aparecem uma vez só, na ordem.
+	tr := build()
+	q := paymentSearch{limit: 5, inRange: func(time.Time) bool { return true }}
+	ids := searchAll(tr, q, func(page int) {
+		for _, s := range tr.entries {
+			if s.RequestedAt == nil {
+				requestedAt := base.Add(time.Hour + time.Duration(page)*time.Minute)
+				s.State, s.RequestedAt = stateProcessedDefault, &requestedAt
+				break
+			}
+		}
+	})
+	seen := make(map[string]int)
+	var unchanged []string
+	for _, id := range ids {
+		seen[id]++
+		var n int
+		fmt.Sscanf(id, "p-%d", &n)
+		if n%2 == 0 {
+			unchanged = append(unchanged, id)
+		}
+	}
+	if len(seen) != 23 || len(unchanged) != 12 {
+		t.Fatalf("%d pagamentos distintos, want 23: %v", len(seen), ids)
+	}
+	for i, id := range unchanged {
+		if want := fmt.Sprintf("p-%02d", 2*i); id != want {
+			t.Fatalf("processados de antes: posição %d = %s, want %s (%v)", i, id, want, ids)
+		}
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	tr := newPaymentTracker()
+	for i := range 10 {
+		id := fmt.Sprintf("p-%d", i)
+		s := &PaymentStatus{CorrelationID: id, Amount: Money(1000 * (i + 1)), State: stateRetrying, AcceptedAt: base.Add(time.Duration(i) * time.Second)}
+		if i%2 == 0 {
+			requestedAt := s.AcceptedAt.Add(time.Second)
+			s.State, s.Processor, s.RequestedAt = stateProcessedDefault, "default", &requestedAt
+		}
+		tr.entries[id] = s
+	}
+
+	minAmount, maxAmount := Money(3000), Money(8000)
+	q := paymentSearch{
+		minAmount: &minAmount,
+		maxAmount: &maxAmount,
+		processor: "default",
+		states:    map[paymentState]bool{stateProcessedDefault: true},
+		inRange:   func(at time.Time) bool { return at.Before(base.Add(7 * time.Second)) },
+		limit:     searchMaxLimit,
+	}
+	got := fmt.Sprint(searchAll(tr, q, func(int) {}))
+	if want := "[p-2 p-4]"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseSearchCursor(t *testing.T) {
+	at := time.Date(2025, 7, 15, 12, 0, 0, 123456789, time.UTC)
+	c, err := parseSearchCursor(searchCursor{at: at, id: "a|b"}.String())
+	if err != nil || !c.at.Equal(at) || c.id != "a|b" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+	for _, bad := range []string{"!!", "bm9waXBl", "eHx5"} {
+		if _, err := parseSearchCursor(bad); err == nil {
+			t.Errorf("cursor %q aceito", bad)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// draining recusa novos pagamentos; workersStopped faz os workers devolverem o que
+	// tirarem da fila em vez de processar, e stopWorkers os encerra.
+	draining       atomic.Bool
+	workersStopped atomic.Bool
+	workersBusy    atomic.Int64
+	stopWorkers    = make(chan struct{})
+	workersDone    sync.WaitGroup
+
+	saverDone    = make(chan struct{})
+	shutdownDone = make(chan struct{})
+
+	pendingFile    = getenvString("PENDING_FILE", "data/pending.jsonl")
+	deadLetterFile = getenvString("DEAD_LETTER_FILE", "data/dead-letters.jsonl")
+)
+
+// awaitShutdown espera SIGTERM/SIGINT e então: para de aceitar pagamentos, deixa os workers
+// esvaziarem a fila, grava em disco o que sobrar e a dead-letter queue, esvazia o saver,
+// fecha o WAL e por fim derruba o servidor HTTP, tudo dentro de SHUTDOWN_TIMEOUT_SEC.
+func awaitShutdown(app *fiber.App) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+
+	timeout := getenvDurationSec("SHUTDOWN_TIMEOUT_SEC", 8)
+	deadline := time.Now().Add(timeout)
+	fmt.Printf("[%s][SHUTDOWN] Received %v, draining within %v\n", getUTCNowFormatted(), sig, timeout)
+
+	draining.Store(true)
+
+	// Reserva um quarto do prazo para persistir as sobras e esvaziar o saver.
+	waitUntil(deadline.Add(-timeout/4), func() bool {
+		return len(queue) == 0 && retryScheduler.Pending() == 0 && workersBusy.Load() == 0
+	})
+
+	// Com o scheduler parado nada mais entra na fila, e o que os workers ainda pegarem fica
+	// retido nele. Só depois que todos os workers saírem o scheduler pode ser esvaziado:
+	// workersBusy não enxerga um pagamento recém-tirado da fila.
+	workersStopped.Store(true)
+	retryScheduler.Stop()
+	close(stopWorkers)
+	workersExited := make(chan struct{})
+	go func() {
+		workersDone.Wait()
+		close(workersExited)
+	}()
+	exited := true
+	select {
+	case <-workersExited:
+	case <-time.After(time.Until(deadline)):
+		exited = false
+	}
+
+	var leftovers []*PaymentRequest
+drain:
+	for {
+		select {
+		case p := <-queue:
+			leftovers = append(leftovers, p)
+		default:
+			break drain
+		}
+	}
+	leftovers = append(leftovers, retryScheduler.Drain()...)
+	if err := savePending(leftovers); err != nil {
+		fmt.Printf("Erro ao salvar pagamentos pendentes: %v\n", err)
+	} else if len(leftovers) > 0 {
+		fmt.Printf("[%s][SHUTDOWN] Saved %d pending payments to %s\n", getUTCNowFormatted(), len(leftovers), pendingFile)
+	}
+	// Sem isso um deploy perderia as dead letters e, com elas, o registro de idempotência:
+	// o cliente poderia reenviar o pagamento como se fosse novo.
+	dead := deadLetters.List()
+	if err := appendJSONLines(deadLetterFile, dead); err != nil {
+		fmt.Printf("Erro ao salvar dead letters: %v\n", err)
+	} else if len(dead) > 0 {
+		fmt.Printf("[%s][SHUTDOWN] Saved %d dead letters to %s\n", getUTCNowFormatted(), len(dead), deadLetterFile)
+	}
+
+	// Um worker preso além do prazo ainda pode enviar para saveChan; nesse caso não dá para fechá-lo.
+	if exited {
+		close(saveChan)
+		select {
+		case <-saverDone:
+		case <-time.After(time.Until(deadline)):
+			fmt.Println("Prazo de shutdown esgotado antes de esvaziar o saver")
+		}
+	} else {
+		fmt.Println("Prazo de shutdown esgotado com workers ainda ocupados")
+	}
+	if paymentLog != nil {
+		if err := paymentLog.Close(); err != nil {
+			fmt.Printf("Erro ao fechar WAL: %v\n", err)
+		}
+	}
+
+	if err := app.ShutdownWithTimeout(max(time.Until(deadline), time.Second)); err != nil {
+		fmt.Printf("Erro ao encerrar servidor HTTP: %v\n", err)
+	}
+	close(shutdownDone)
+}
+
+func waitUntil(deadline time.Time, done func() bool) {
+	for !done() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// savePending grava os pagamentos que não chegaram a ser processados, um JSON por linha.
+func savePending(payments []*PaymentRequest) error {
+	return appendJSONLines(pendingFile, payments)
+}
+
+// appendJSONLines acrescenta items a path, um JSON por linha, e sincroniza o arquivo.
+func appendJSONLines[T any](path string, items []T) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		if err = enc.Encode(item); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// restorePending devolve à fila os pagamentos salvos no último shutdown.
+func restorePending() {
+	f, err := os.Open(pendingFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Erro ao abrir pagamentos pendentes: %v\n", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	restored := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var p PaymentRequest
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			fmt.Printf("Erro ao ler pagamento pendente: %v\n", err)
+			continue
+		}
+		if _, ok := tracker.Claim(p); !ok {
+			continue
+		}
+		retryScheduler.Schedule(&p, time.Now())
+		restored++
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Erro ao ler pagamentos pendentes: %v\n", err)
+		return
+	}
+
+	if err := os.Remove(pendingFile); err != nil {
+		fmt.Printf("Erro ao remover pagamentos pendentes: %v\n", err)
+	}
+	fmt.Printf("[%s][SHUTDOWN] Restored %d pending payments\n", getUTCNowFormatted(), restored)
+}
+
+// restoreDeadLetters devolve à dead-letter queue e ao tracker o que estava nela no último
+// shutdown, para que continue visível, reenviável e deduplicado.
+func restoreDeadLetters() {
+	f, err := os.Open(deadLetterFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Erro ao abrir dead letters: %v\n", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	restored := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var dl DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			fmt.Printf("Erro ao ler dead letter: %v\n", err)
+			continue
+		}
+		if !tracker.RestoreDeadLetter(dl) {
+			continue
+		}
+		deadLetters.Restore(dl)
+		restored++
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Erro ao ler dead letters: %v\n", err)
+		return
+	}
+
+	if err := os.Remove(deadLetterFile); err != nil {
+		fmt.Printf("Erro ao remover dead letters: %v\n", err)
+	}
+	fmt.Printf("[%s][SHUTDOWN] Restored %d dead letters\n", getUTCNowFormatted(), restored)
+}
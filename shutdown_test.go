@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeadLettersSurviveRestart(t *testing.T) {
+	savedFile, savedStore, savedTracker := deadLetterFile, deadLetters, tracker
+	defer func() { deadLetterFile, deadLetters, tracker = savedFile, savedStore, savedTracker }()
+
+	deadLetterFile = filepath.Join(t.TempDir(), "data", "dead-letters.jsonl")
+	deadLetters, tracker = newDeadLetterStore(), newPaymentTracker()
+	payments := walPayments(3)
+	for i := range payments {
+		p := &payments[i]
+		p.Processor, p.Fallback = "", false
+		p.attempts = 5
+		deadLetters.Add(p, errors.New("processadores indisponíveis"))
+	}
+	if err := appendJSONLines(deadLetterFile, deadLetters.List()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Um boot novo: o primeiro já foi reprocessado e está no tracker pelo WAL.
+	deadLetters, tracker = newDeadLetterStore(), newPaymentTracker()
+	tracker.Restore(payments[0])
+	restoreDeadLetters()
+
+	if _, ok := deadLetters.Get(payments[0].CorrelationID); ok {
+		t.Fatal("dead letter de um pagamento já processado restaurada")
+	}
+	for _, p := range payments[1:] {
+		dl, ok := deadLetters.Get(p.CorrelationID)
+		if !ok || dl.Attempts != 5 || dl.Payment.Amount != p.Amount {
+			t.Fatalf("dead letter %s = %+v, %v", p.CorrelationID, dl, ok)
+		}
+		st, ok := tracker.Get(p.CorrelationID)
+		if !ok || st.State != stateFailed || st.Attempts != 5 {
+			t.Fatalf("tracker %s = %+v, %v; want failed com 5 tentativas", p.CorrelationID, st, ok)
+		}
+	}
+	if _, err := os.Stat(deadLetterFile); !os.IsNotExist(err) {
+		t.Fatalf("arquivo de dead letters mantido depois do boot: %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formato do snapshot (little-endian):
+//
+//	magic "RSNP" | versão (uint32) | posição do WAL coberta: segmento (uint64), offset (int64) |
+//	totais: defaultCount (uint64), defaultAmount em centavos (int64), fallbackCount (uint64), fallbackAmount em centavos (int64) |
+//	quantidade de registros (uint64) | registros: tamanho (uint32) + payload no mesmo formato do WAL |
+//	crc32c de tudo que vem antes (uint32)
+const (
+	snapshotMagic   = "RSNP"
+	snapshotVersion = 1
+	snapshotPrefix  = "snapshot-"
+	snapshotSuffix  = ".snap"
+
+	snapshotHeaderSize = 64
+	// snapshotMinRecordSize é o tamanho do registro mais curto: 4 bytes de tamanho e o menor
+	// payload que decodeWALPayload aceita.
+	snapshotMinRecordSize = 4 + walMinPayloadSize
+)
+
+type snapshotter struct {
+	dir    string
+	retain int
+}
+
+// loadLatest carrega o snapshot válido mais recente, caindo para os anteriores se algum estiver corrompido.
+func (s *snapshotter) loadLatest() (WALPosition, []PaymentRequest, bool) {
+	names, err := s.list()
+	if err != nil {
+		fmt.Printf("Erro ao listar snapshots: %v\n", err)
+		return WALPosition{}, nil, false
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		path := filepath.Join(s.dir, names[i])
+		pos, payments, err := readSnapshot(path)
+		if err != nil {
+			fmt.Printf("[%s][SNAPSHOT] Ignoring %s: %v\n", getUTCNowFormatted(), names[i], err)
+			continue
+		}
+		return pos, payments, true
+	}
+	return WALPosition{}, nil, false
+}
+
+func (s *snapshotter) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.take(); err != nil {
+			fmt.Printf("Erro ao gerar snapshot: %v\n", err)
+		}
+	}
+}
+
+// take grava um snapshot do storage atual e compacta o WAL até o snapshot mais antigo mantido.
+func (s *snapshotter) take() error {
+	storageMutex.RLock()
+	pos := paymentLog.Position()
+	payments := make([]PaymentRequest, len(storage))
+	copy(payments, storage)
+	storageMutex.RUnlock()
+
+	// Flush antes do snapshot: ele não pode cobrir registros que ainda não chegaram ao disco.
+	if err := paymentLog.Sync(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s%020d-%020d%s", snapshotPrefix, pos.Segment, pos.Offset, snapshotSuffix)
+	if err := writeSnapshot(filepath.Join(s.dir, name), pos, payments); err != nil {
+		return err
+	}
+	fmt.Printf("[%s][SNAPSHOT] Wrote %s with %d payments\n", getUTCNowFormatted(), name, len(payments))
+
+	return s.compact()
+}
+
+func (s *snapshotter) compact() error {
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	if len(names) > s.retain {
+		for _, name := range names[:len(names)-s.retain] {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return err
+			}
+		}
+		names = names[len(names)-s.retain:]
+	}
+
+	// Mantém os segmentos necessários para o snapshot mais antigo, caso o mais novo esteja corrompido.
+	oldest, err := readSnapshotPosition(filepath.Join(s.dir, names[0]))
+	if err != nil {
+		return err
+	}
+	return paymentLog.RemoveSegmentsBefore(oldest.Segment)
+}
+
+func (s *snapshotter) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			out = append(out, name)
+		}
+	}
+	// Os nomes têm largura fixa, então a ordem lexicográfica é a ordem do log.
+	sort.Strings(out)
+	return out, nil
+}
+
+func writeSnapshot(path string, pos WALPosition, payments []PaymentRequest) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.New(walCRCTable)
+	w := bufio.NewWriterSize(io.MultiWriter(f, crc), 64<<10)
+
+	totals := computeSnapshotTotals(payments)
+	header := make([]byte, 0, 64)
+	header = append(header, snapshotMagic...)
+	header = binary.LittleEndian.AppendUint32(header, snapshotVersion)
+	header = binary.LittleEndian.AppendUint64(header, pos.Segment)
+	header = binary.LittleEndian.AppendUint64(header, uint64(pos.Offset))
+	header = binary.LittleEndian.AppendUint64(header, uint64(totals.DefaultCount))
+	header = binary.LittleEndian.AppendUint64(header, uint64(totals.DefaultAmount))
+	header = binary.LittleEndian.AppendUint64(header, uint64(totals.FallbackCount))
+	header = binary.LittleEndian.AppendUint64(header, uint64(totals.FallbackAmount))
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(payments)))
+	_, err = w.Write(header)
+
+	var size [4]byte
+	for _, p := range payments {
+		if err != nil {
+			break
+		}
+		payload := encodeWALPayload(p)
+		binary.LittleEndian.PutUint32(size[:], uint32(len(payload)))
+		if _, err = w.Write(size[:]); err == nil {
+			_, err = w.Write(payload)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+		_, err = f.Write(sum[:])
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func readSnapshot(path string) (WALPosition, []PaymentRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return WALPosition{}, nil, err
+	}
+	defer f.Close()
+
+	crc := crc32.New(walCRCTable)
+	r := io.TeeReader(bufio.NewReaderSize(f, 64<<10), crc)
+
+	info, err := f.Stat()
+	if err != nil {
+		return WALPosition{}, nil, err
+	}
+
+	pos, totals, count, err := readSnapshotHeader(r)
+	if err != nil {
+		return WALPosition{}, nil, err
+	}
+	// A quantidade vem do cabeçalho, que só é conferido pelo CRC no fim: uma que não cabe no
+	// arquivo é corrupção e não pode virar uma alocação gigante.
+	if count > uint64(max(info.Size()-snapshotHeaderSize-4, 0))/snapshotMinRecordSize {
+		return WALPosition{}, nil, errWALCorrupt
+	}
+
+	payments := make([]PaymentRequest, 0, count)
+	var size [4]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return WALPosition{}, nil, errWALCorrupt
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n == 0 || n > walMaxRecordSize {
+			return WALPosition{}, nil, errWALCorrupt
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return WALPosition{}, nil, errWALCorrupt
+		}
+		p, err := decodeWALPayload(payload)
+		if err != nil {
+			return WALPosition{}, nil, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err := verifySnapshotChecksum(r, crc); err != nil {
+		return WALPosition{}, nil, err
+	}
+	if computeSnapshotTotals(payments) != totals {
+		return WALPosition{}, nil, fmt.Errorf("totais do snapshot não conferem")
+	}
+	return pos, payments, nil
+}
+
+func readSnapshotPosition(path string) (WALPosition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return WALPosition{}, err
+	}
+	defer f.Close()
+
+	pos, _, _, err := readSnapshotHeader(f)
+	return pos, err
+}
+
+func readSnapshotHeader(r io.Reader) (WALPosition, summaryTotals, uint64, error) {
+	var header [snapshotHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return WALPosition{}, summaryTotals{}, 0, errWALCorrupt
+	}
+	version := binary.LittleEndian.Uint32(header[4:8])
+	if string(header[0:4]) != snapshotMagic || version != snapshotVersion {
+		return WALPosition{}, summaryTotals{}, 0, errWALCorrupt
+	}
+
+	pos := WALPosition{
+		Segment: binary.LittleEndian.Uint64(header[8:16]),
+		Offset:  int64(binary.LittleEndian.Uint64(header[16:24])),
+	}
+	totals := summaryTotals{
+		DefaultCount:   int(binary.LittleEndian.Uint64(header[24:32])),
+		DefaultAmount:  Money(binary.LittleEndian.Uint64(header[32:40])),
+		FallbackCount:  int(binary.LittleEndian.Uint64(header[40:48])),
+		FallbackAmount: Money(binary.LittleEndian.Uint64(header[48:56])),
+	}
+	return pos, totals, binary.LittleEndian.Uint64(header[56:64]), nil
+}
+
+func verifySnapshotChecksum(r io.Reader, crc hash.Hash32) error {
+	expected := crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return errWALCorrupt
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != expected {
+		return errWALCorrupt
+	}
+	return nil
+}
+
+func computeSnapshotTotals(payments []PaymentRequest) summaryTotals {
+	var t summaryTotals
+	for _, p := range payments {
+		t.add(p)
+	}
+	return t
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
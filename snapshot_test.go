@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot-test.snap")
+	payments := walPayments(50)
+	pos := WALPosition{Segment: 3, Offset: 4242}
+
+	if err := writeSnapshot(path, pos, payments); err != nil {
+		t.Fatal(err)
+	}
+	gotPos, got, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPos != pos {
+		t.Fatalf("posição %+v, want %+v", gotPos, pos)
+	}
+	checkPayments(t, got, payments)
+
+	// Qualquer byte trocado tem que ser pego pelo CRC.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Os bytes 58 e 63 inflam a quantidade de registros (para ~8 milhões e para além do que
+	// cabe num slice): nenhuma das duas pode derrubar o boot antes do CRC ser conferido.
+	for _, i := range []int{0, 20, 58, 63, 70, len(data) - 1} {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0x80
+		if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := readSnapshot(path); err == nil {
+			t.Errorf("snapshot com o byte %d trocado aceito", i)
+		}
+	}
+}
+
+// TestSnapshotRecovery reproduz o boot de initWAL: carrega o snapshot mais recente e relê o
+// WAL só a partir da posição que ele cobre.
+func TestSnapshotRecovery(t *testing.T) {
+	savedLog, savedStorage := paymentLog, storage
+	defer func() { paymentLog, storage = savedLog, savedStorage }()
+
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	snapshots := &snapshotter{dir: filepath.Join(dir, "snapshots"), retain: 2}
+	if err := os.MkdirAll(snapshots.dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	payments := walPayments(300)
+	w, _ := recoverAll(t, walDir, WALPosition{})
+	paymentLog, storage = w, nil
+	for i, p := range payments {
+		if err := w.Append(p); err != nil {
+			t.Fatal(err)
+		}
+		storage = append(storage, p)
+		if i == 99 || i == 199 || i == 249 {
+			if err := snapshots.take(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	w.Close()
+
+	names, err := snapshots.list()
+	if err != nil || len(names) != 2 {
+		t.Fatalf("snapshots %v, %v; want os 2 mais recentes", names, err)
+	}
+	// A compactação apagou os segmentos que nem o snapshot mais antigo mantido precisa.
+	if segments, _ := w.segments(); segments[0] == 1 {
+		t.Fatalf("segmentos %v: o WAL não foi compactado", segments)
+	}
+
+	restore := func() []PaymentRequest {
+		t.Helper()
+		from, got, ok := snapshots.loadLatest()
+		if !ok {
+			t.Fatal("nenhum snapshot carregado")
+		}
+		w, tail := recoverAll(t, walDir, from)
+		w.Close()
+		return append(got, tail...)
+	}
+	checkPayments(t, restore(), payments)
+
+	// Com o snapshot mais novo corrompido, o anterior e o WAL mantido bastam.
+	newest := filepath.Join(snapshots.dir, names[1])
+	if err := os.Truncate(newest, 100); err != nil {
+		t.Fatal(err)
+	}
+	checkPayments(t, restore(), payments)
+}
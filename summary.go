@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// O resumo do cluster é montado pelo líder pedindo o resumo local de todos os nós ao mesmo
+// tempo, com um único prazo (SUMMARY_DEADLINE_MS) para a requisição inteira. A resposta diz
+// quais nós entraram na soma e quais falharam; em modo estrito (strict=true ou SUMMARY_STRICT)
+// qualquer falha vira 503 em vez de um total incompleto.
+//
+// Em modo consistente (consistent=true ou SUMMARY_CONSISTENT) cada nó só responde depois que
+// sua persistWatermark alcança o fim da janela, então duas chamadas para a mesma janela
+// fechada dão o mesmo total. Sem from/to a janela vai até o instante do pedido. O modo
+// consistente é sempre estrito: um nó que não alcança a marca no prazo faz a resposta ser 503.
+//
+// Com groupBy (second, minute, hour ou uma duração como 15s ou 250ms) a resposta traz também
+// series, os totais por intervalo alinhado à época Unix, somados entre os nós. Intervalos sem
+// pagamentos não aparecem. É recusada uma janela from/to com mais de
+// SUMMARY_SERIES_MAX_POINTS intervalos e também, com ou sem from/to, uma série que passaria
+// disso dentro do trecho em que este nó tem pagamentos; sem essa segunda conta groupBy=1ms
+// sem janela devolveria um ponto por milissegundo de todo o histórico.
+var (
+	summaryDeadline   = getenvDurationMS("SUMMARY_DEADLINE_MS", 1500)
+	summaryStrict     = getenvBool("SUMMARY_STRICT", false)
+	summaryConsistent = getenvBool("SUMMARY_CONSISTENT", false)
+	summaryMaxPoints  = getenvInt64("SUMMARY_SERIES_MAX_POINTS", 10000)
+)
+
+type summaryQuery struct {
+	from, to      time.Time
+	useDateFilter bool
+	consistent    bool
+	groupBy       time.Duration
+}
+
+func parseGroupBy(value string) (time.Duration, error) {
+	switch value {
+	case "second":
+		return time.Second, nil
+	case "minute":
+		return time.Minute, nil
+	case "hour":
+		return time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Millisecond || d%time.Millisecond != 0 {
+		return 0, fmt.Errorf("groupBy inválido: %q", value)
+	}
+	return d, nil
+}
+
+type SummaryBucket struct {
+	TotalRequests int   `json:"totalRequests"`
+	TotalAmount   Money `json:"totalAmount"`
+}
+
+type SummaryNodes struct {
+	Contributed []string          `json:"contributed"`
+	Failed      map[string]string `json:"failed,omitempty"`
+}
+
+type SummaryPoint struct {
+	Start    time.Time     `json:"start"`
+	Default  SummaryBucket `json:"default"`
+	Fallback SummaryBucket `json:"fallback"`
+}
+
+type SummaryResponse struct {
+	Default  SummaryBucket  `json:"default"`
+	Fallback SummaryBucket  `json:"fallback"`
+	Series   []SummaryPoint `json:"series,omitempty"`
+	// Nodes só vem no resumo agregado; o resumo local de um nó não tem.
+	Nodes *SummaryNodes `json:"nodes,omitempty"`
+	// Watermark vem no modo consistente: a marca do nó ou, no agregado, a menor entre os nós.
+	Watermark *time.Time `json:"watermark,omitempty"`
+}
+
+func (s *SummaryResponse) add(other SummaryResponse) {
+	s.Default.TotalRequests += other.Default.TotalRequests
+	s.Default.TotalAmount += other.Default.TotalAmount
+	s.Fallback.TotalRequests += other.Fallback.TotalRequests
+	s.Fallback.TotalAmount += other.Fallback.TotalAmount
+	s.Series = mergeSeries(s.Series, other.Series)
+}
+
+// mergeSeries junta duas séries ordenadas somando os pontos do mesmo intervalo.
+func mergeSeries(a, b []SummaryPoint) []SummaryPoint {
+	if len(a) == 0 {
+		return b
+	}
+	out := make([]SummaryPoint, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i].Start.Before(b[j].Start)):
+			out = append(out, a[i])
+			i++
+		case i == len(a) || b[j].Start.Before(a[i].Start):
+			out = append(out, b[j])
+			j++
+		default:
+			p := a[i]
+			p.Default.TotalRequests += b[j].Default.TotalRequests
+			p.Default.TotalAmount += b[j].Default.TotalAmount
+			p.Fallback.TotalRequests += b[j].Fallback.TotalRequests
+			p.Fallback.TotalAmount += b[j].Fallback.TotalAmount
+			out = append(out, p)
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// paymentsSummaryHandler atende o resumo público e, com peer, a rota interna pela qual os nós
+// pedem o resumo local (internal=true) ou a agregação ao líder (forwarded=true).
+func paymentsSummaryHandler(peer bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		fromStr := c.Query("from")
+		toStr := c.Query("to")
+		internal, forwarded := "false", false
+		deadline := summaryDeadline
+		if peer {
+			internal = c.Query("internal", "false")
+			forwarded = c.Query("forwarded", "false") == "true"
+			// Quem repassou ao líder espera só até o prazo dele; o líder tem que responder antes.
+			if budget := c.QueryInt("budgetMs", 0); budget > 0 {
+				deadline = min(deadline, time.Duration(budget)*time.Millisecond)
+			}
+		}
+		consistent := c.QueryBool("consistent", summaryConsistent)
+		strict := consistent || c.QueryBool("strict", summaryStrict)
+
+		var from, to time.Time
+		var err error
+		useDateFilter := false
+		// synthesized marca a janela que o modo consistente cria sem from/to: ela vai da
+		// época Unix até agora e não serve para contar os intervalos da série.
+		synthesized := false
+
+		if fromStr != "" && toStr != "" {
+			from, err = time.Parse("2006-01-02T15:04:05.000Z", fromStr)
+			if err != nil {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			to, err = time.Parse("2006-01-02T15:04:05.000Z", toStr)
+			if err != nil {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+
+			useDateFilter = true
+		} else if consistent {
+			from, to = time.Unix(0, 0).UTC(), time.Now().UTC()
+			useDateFilter, synthesized = true, true
+		}
+		q := summaryQuery{from: from, to: to, useDateFilter: useDateFilter, consistent: consistent}
+		if groupBy := c.Query("groupBy"); groupBy != "" {
+			if q.groupBy, err = parseGroupBy(groupBy); err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+			}
+			if useDateFilter && !synthesized && to.Sub(from)/q.groupBy >= time.Duration(summaryMaxPoints) {
+				return c.Status(fiber.StatusBadRequest).SendString("groupBy gera intervalos demais para a janela")
+			}
+			if seriesIntervals(q) >= summaryMaxPoints {
+				return c.Status(fiber.StatusBadRequest).SendString("groupBy gera intervalos demais para os pagamentos guardados")
+			}
+		}
+
+		fmt.Printf("[%s][SUMMARY-REQUEST] Receiving request with from: %v and %v\n",
+			getUTCNowFormatted(), formatDate(from), formatDate(to))
+
+		if internal == "true" {
+			// Responde antes do prazo de quem pediu para que a resposta ainda seja lida.
+			summary, err := localPaymentSummary(q, deadline-deadline/10)
+			if err != nil {
+				return c.Status(fiber.StatusServiceUnavailable).SendString(err.Error())
+			}
+			return c.Status(fiber.StatusOK).JSON(summary)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		defer cancel()
+
+		// A agregação fica com o líder; sem líder conhecido, ou se ele não responder na
+		// metade do prazo, este nó agrega por conta própria no que sobrar.
+		var summary SummaryResponse
+		aggregated := false
+		leader := election.Leader()
+		if !forwarded && leader != "" && leader != nodeURL {
+			leaderCtx, leaderCancel := context.WithTimeout(ctx, deadline/2)
+			summary, err = fetchPaymentSummary(leaderCtx, q, false, leader)
+			leaderCancel()
+			if err != nil {
+				fmt.Printf("Erro ao buscar resumo no líder %s: %v\n", leader, err)
+			}
+			aggregated = err == nil && summary.Nodes != nil
+		}
+		if !aggregated {
+			summary = aggregatePaymentSummary(ctx, q)
+		}
+
+		if len(summary.Nodes.Failed) > 0 {
+			fmt.Printf("[%s][SUMMARY-REQUEST] Partial summary, failed nodes: %v\n",
+				getUTCNowFormatted(), summary.Nodes.Failed)
+			if strict {
+				return c.Status(fiber.StatusServiceUnavailable).JSON(summary)
+			}
+		}
+		return c.Status(fiber.StatusOK).JSON(summary)
+	}
+}
+
+// localPaymentSummary é o resumo deste nó; no modo consistente espera até wait pela marca d'água.
+func localPaymentSummary(q summaryQuery, wait time.Duration) (SummaryResponse, error) {
+	var mark time.Time
+	if q.consistent {
+		var ok bool
+		if mark, ok = persistWatermark.WaitFor(q.to, wait); !ok {
+			return SummaryResponse{}, fmt.Errorf("marca d'água %s ainda não alcançou %s",
+				formatDate(mark), formatDate(q.to))
+		}
+	}
+
+	summary := getPaymentSummary(q.from, q.to, q.useDateFilter)
+	if q.groupBy > 0 {
+		summary.Series = getPaymentSeries(q)
+	}
+	if q.consistent {
+		summary.Watermark = &mark
+	}
+	return summary, nil
+}
+
+// seriesIntervals limita quantos pontos a série de q pode ter neste nó: a janela pedida
+// recortada ao trecho em que há pagamentos, dividida pelo intervalo.
+func seriesIntervals(q summaryQuery) int64 {
+	first, last, ok := summaryIndex.Span()
+	if !ok {
+		return 0
+	}
+	if q.useDateFilter {
+		if q.from.After(first) {
+			first = q.from
+		}
+		if q.to.Before(last) {
+			last = q.to
+		}
+	}
+	if last.Before(first) {
+		return 0
+	}
+	return int64(last.Sub(first)/q.groupBy) + 1
+}
+
+func getPaymentSummary(from, to time.Time, useDateFilter bool) SummaryResponse {
+	t := summaryIndex.Summary(from, to, useDateFilter)
+	return SummaryResponse{
+		Default:  SummaryBucket{TotalRequests: t.DefaultCount, TotalAmount: t.DefaultAmount},
+		Fallback: SummaryBucket{TotalRequests: t.FallbackCount, TotalAmount: t.FallbackAmount},
+	}
+}
+
+func getPaymentSeries(q summaryQuery) []SummaryPoint {
+	points := summaryIndex.Series(q.from, q.to, q.useDateFilter, q.groupBy.Milliseconds())
+	out := make([]SummaryPoint, len(points))
+	for i, p := range points {
+		out[i] = SummaryPoint{
+			Start:    time.UnixMilli(p.StartMs).UTC(),
+			Default:  SummaryBucket{TotalRequests: p.Totals.DefaultCount, TotalAmount: p.Totals.DefaultAmount},
+			Fallback: SummaryBucket{TotalRequests: p.Totals.FallbackCount, TotalAmount: p.Totals.FallbackAmount},
+		}
+	}
+	return out
+}
+
+// aggregatePaymentSummary soma o resumo deste e de todos os outros nós, pedidos em paralelo;
+// um nó que não responde até o prazo de ctx fica em Nodes.Failed. Os seeds entram mesmo fora
+// do membership, já que um seed morto ainda tem pagamentos que faltariam na soma.
+func aggregatePaymentSummary(ctx context.Context, q summaryQuery) SummaryResponse {
+	summary := SummaryResponse{Nodes: &SummaryNodes{}}
+
+	type nodeSummary struct {
+		node    string
+		summary SummaryResponse
+		err     error
+	}
+	nodes := membership.Nodes()
+	if nodeURL == "" {
+		nodes = append(nodes, nodeURL)
+	}
+	results := make(chan nodeSummary, len(nodes))
+	for _, node := range nodes {
+		go func(node string) {
+			if node == nodeURL {
+				wait := summaryDeadline
+				if deadline, ok := ctx.Deadline(); ok {
+					wait = time.Until(deadline)
+				}
+				s, err := localPaymentSummary(q, wait)
+				results <- nodeSummary{node: node, summary: s, err: err}
+				return
+			}
+			s, err := fetchPaymentSummary(ctx, q, true, node)
+			results <- nodeSummary{node: node, summary: s, err: err}
+		}(node)
+	}
+
+	for range nodes {
+		r := <-results
+		if r.err != nil {
+			if summary.Nodes.Failed == nil {
+				summary.Nodes.Failed = make(map[string]string)
+			}
+			summary.Nodes.Failed[r.node] = r.err.Error()
+			continue
+		}
+		summary.add(r.summary)
+		summary.Nodes.Contributed = append(summary.Nodes.Contributed, r.node)
+		if mark := r.summary.Watermark; mark != nil && (summary.Watermark == nil || mark.Before(*summary.Watermark)) {
+			summary.Watermark = mark
+		}
+	}
+	sort.Strings(summary.Nodes.Contributed)
+	return summary
+}
+
+func fetchPaymentSummary(ctx context.Context, sq summaryQuery, internal bool, baseUrl string) (SummaryResponse, error) {
+	var parsed SummaryResponse
+
+	endpoint, err := url.Parse(baseUrl + "/internal/payments-summary")
+	if err != nil {
+		return parsed, fmt.Errorf("erro ao montar URL: %w", err)
+	}
+
+	q := endpoint.Query()
+	if sq.useDateFilter {
+		q.Set("from", sq.from.Format("2006-01-02T15:04:05.000Z"))
+		q.Set("to", sq.to.Format("2006-01-02T15:04:05.000Z"))
+	}
+	q.Set("consistent", strconv.FormatBool(sq.consistent))
+	if sq.groupBy > 0 {
+		q.Set("groupBy", sq.groupBy.String())
+	}
+	if internal {
+		q.Set("internal", "true")
+	} else {
+		// Repasse ao líder: ele agrega mesmo que ainda não se veja como líder.
+		q.Set("forwarded", "true")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		q.Set("budgetMs", strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	endpoint.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.String(), nil)
+	if err != nil {
+		return parsed, fmt.Errorf("erro ao montar requisição: %w", err)
+	}
+	signRequest(req, nil)
+
+	resp, err := circuitClient.Do(req)
+	if err != nil {
+		return parsed, fmt.Errorf("erro na requisição: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return parsed, fmt.Errorf("erro HTTP: status %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return parsed, fmt.Errorf("erro ao decodificar JSON: %w", err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// O índice agrega os pagamentos por segundo e, dentro de cada segundo, por milissegundo.
+// Segundos totalmente dentro de [from, to] usam o agregado do segundo; apenas os segundos
+// das bordas descem para os milissegundos. Como tryProcessPayment trunca RequestedAt em
+// milissegundos, arredondar from para cima e to para baixo em ms dá o mesmo resultado da
+// varredura linear.
+var summaryIndex = newSummaryIndex()
+
+type summaryTotals struct {
+	DefaultCount   int
+	DefaultAmount  Money
+	FallbackCount  int
+	FallbackAmount Money
+}
+
+func (t *summaryTotals) add(p PaymentRequest) {
+	if p.Fallback {
+		t.FallbackCount++
+		t.FallbackAmount += p.Amount
+	} else {
+		t.DefaultCount++
+		t.DefaultAmount += p.Amount
+	}
+}
+
+func (t *summaryTotals) merge(o summaryTotals) {
+	t.DefaultCount += o.DefaultCount
+	t.DefaultAmount += o.DefaultAmount
+	t.FallbackCount += o.FallbackCount
+	t.FallbackAmount += o.FallbackAmount
+}
+
+type seriesPoint struct {
+	StartMs int64
+	Totals  summaryTotals
+}
+
+type secondBucket struct {
+	totals summaryTotals
+	millis map[int64]*summaryTotals
+}
+
+type SummaryIndex struct {
+	mu      sync.RWMutex
+	total   summaryTotals
+	seconds []int64
+	buckets map[int64]*secondBucket
+}
+
+func newSummaryIndex() *SummaryIndex {
+	return &SummaryIndex{buckets: make(map[int64]*secondBucket)}
+}
+
+func (idx *SummaryIndex) Add(p PaymentRequest) {
+	ms := p.RequestedAt.UnixMilli()
+	sec := floorDiv(ms, 1000)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.total.add(p)
+
+	b := idx.buckets[sec]
+	if b == nil {
+		b = &secondBucket{millis: make(map[int64]*summaryTotals)}
+		idx.buckets[sec] = b
+		idx.insertSecond(sec)
+	}
+	b.totals.add(p)
+
+	m := b.millis[ms]
+	if m == nil {
+		m = &summaryTotals{}
+		b.millis[ms] = m
+	}
+	m.add(p)
+}
+
+func (idx *SummaryIndex) AddAll(payments []PaymentRequest) {
+	for _, p := range payments {
+		idx.Add(p)
+	}
+}
+
+// Summary soma os pagamentos com from <= RequestedAt <= to (ou todos, sem filtro de data).
+func (idx *SummaryIndex) Summary(from, to time.Time, useDateFilter bool) summaryTotals {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if !useDateFilter {
+		return idx.total
+	}
+
+	var res summaryTotals
+	lo, hi := ceilMilli(from), to.UnixMilli()
+	if lo > hi {
+		return res
+	}
+	loSec, hiSec := floorDiv(lo, 1000), floorDiv(hi, 1000)
+
+	i := sort.Search(len(idx.seconds), func(i int) bool { return idx.seconds[i] >= loSec })
+	for ; i < len(idx.seconds) && idx.seconds[i] <= hiSec; i++ {
+		sec := idx.seconds[i]
+		b := idx.buckets[sec]
+		if sec*1000 >= lo && sec*1000+999 <= hi {
+			res.merge(b.totals)
+			continue
+		}
+		for ms, t := range b.millis {
+			if ms >= lo && ms <= hi {
+				res.merge(*t)
+			}
+		}
+	}
+	return res
+}
+
+// Span devolve o início do primeiro e o fim do último segundo com pagamentos; ok é falso
+// com o índice vazio.
+func (idx *SummaryIndex) Span() (first, last time.Time, ok bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if len(idx.seconds) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	first = time.UnixMilli(idx.seconds[0] * 1000).UTC()
+	last = time.UnixMilli(idx.seconds[len(idx.seconds)-1]*1000 + 999).UTC()
+	return first, last, true
+}
+
+// insertSecond mantém idx.seconds ordenado; no caso comum o segundo novo é o maior.
+func (idx *SummaryIndex) insertSecond(sec int64) {
+	n := len(idx.seconds)
+	if n == 0 || idx.seconds[n-1] < sec {
+		idx.seconds = append(idx.seconds, sec)
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return idx.seconds[i] >= sec })
+	idx.seconds = append(idx.seconds, 0)
+	copy(idx.seconds[i+1:], idx.seconds[i:])
+	idx.seconds[i] = sec
+}
+
+// Series agrupa os totais em intervalos de intervalMs alinhados à época Unix, em ordem, e
+// omite os intervalos sem pagamentos. Segundos inteiros no range só descem para os
+// milissegundos quando o intervalo não é múltiplo de um segundo.
+func (idx *SummaryIndex) Series(from, to time.Time, useDateFilter bool, intervalMs int64) []seriesPoint {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if useDateFilter {
+		lo, hi = ceilMilli(from), to.UnixMilli()
+	}
+	if lo > hi || intervalMs <= 0 {
+		return nil
+	}
+
+	points := make(map[int64]*summaryTotals)
+	addTo := func(ms int64, t summaryTotals) {
+		start := floorDiv(ms, intervalMs) * intervalMs
+		if points[start] == nil {
+			points[start] = &summaryTotals{}
+		}
+		points[start].merge(t)
+	}
+
+	i := sort.Search(len(idx.seconds), func(i int) bool { return idx.seconds[i] >= floorDiv(lo, 1000) })
+	for ; i < len(idx.seconds) && idx.seconds[i] <= floorDiv(hi, 1000); i++ {
+		sec := idx.seconds[i]
+		b := idx.buckets[sec]
+		if intervalMs%1000 == 0 && sec*1000 >= lo && sec*1000+999 <= hi {
+			addTo(sec*1000, b.totals)
+			continue
+		}
+		for ms, t := range b.millis {
+			if ms >= lo && ms <= hi {
+				addTo(ms, *t)
+			}
+		}
+	}
+
+	out := make([]seriesPoint, 0, len(points))
+	for start, t := range points {
+		out = append(out, seriesPoint{StartMs: start, Totals: *t})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartMs < out[j].StartMs })
+	return out
+}
+
+func ceilMilli(t time.Time) int64 {
+	ms := t.UnixMilli()
+	if t.Nanosecond()%int(time.Millisecond) != 0 {
+		ms++
+	}
+	return ms
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// scanSummary é a varredura linear que getPaymentSummary fazia antes do índice.
+func scanSummary(payments []PaymentRequest, from, to time.Time, useDateFilter bool) summaryTotals {
+	var t summaryTotals
+	for _, p := range payments {
+		if useDateFilter {
+			if p.RequestedAt.Before(from) || p.RequestedAt.After(to) {
+				continue
+			}
+		}
+		t.add(p)
+	}
+	return t
+}
+
+func TestSummaryIndexMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	idx := newSummaryIndex()
+	var payments []PaymentRequest
+	for i := 0; i < 5000; i++ {
+		p := PaymentRequest{
+			CorrelationID: "p",
+			Amount:        Money(rng.Intn(100000)),
+			RequestedAt:   base.Add(time.Duration(rng.Intn(120000)) * time.Millisecond),
+			Fallback:      rng.Intn(4) == 0,
+		}
+		payments = append(payments, p)
+		idx.Add(p)
+	}
+
+	check := func(from, to time.Time, useDateFilter bool) {
+		t.Helper()
+		got := idx.Summary(from, to, useDateFilter)
+		want := scanSummary(payments, from, to, useDateFilter)
+		if got != want {
+			t.Fatalf("summary(%s, %s, %v) = %+v, want %+v",
+				formatDate(from), formatDate(to), useDateFilter, got, want)
+		}
+	}
+
+	check(time.Time{}, time.Time{}, false)
+	check(base, base.Add(2*time.Minute), true)
+	check(base.Add(time.Minute), base, true)
+
+	for i := 0; i < 2000; i++ {
+		from := base.Add(time.Duration(rng.Intn(130000)-5000) * time.Millisecond)
+		to := from.Add(time.Duration(rng.Intn(10000)) * time.Millisecond)
+		check(from, to, true)
+		// Bordas exatamente sobre um pagamento existente.
+		p := payments[rng.Intn(len(payments))]
+		check(p.RequestedAt, p.RequestedAt, true)
+		check(p.RequestedAt, to, true)
+		check(from, p.RequestedAt, true)
+		// Bordas fora do grid de milissegundos.
+		check(from.Add(300*time.Microsecond), to.Add(700*time.Microsecond), true)
+	}
+}
+
+// scanSeries é a série calculada pagamento a pagamento, para conferir SummaryIndex.Series.
+func scanSeries(payments []PaymentRequest, from, to time.Time, useDateFilter bool, intervalMs int64) map[int64]summaryTotals {
+	out := make(map[int64]summaryTotals)
+	for _, p := range payments {
+		if useDateFilter && (p.RequestedAt.Before(from) || p.RequestedAt.After(to)) {
+			continue
+		}
+		start := floorDiv(p.RequestedAt.UnixMilli(), intervalMs) * intervalMs
+		t := out[start]
+		t.add(p)
+		out[start] = t
+	}
+	return out
+}
+
+func TestSummaryIndexSeriesMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	idx := newSummaryIndex()
+	var payments []PaymentRequest
+	for i := 0; i < 3000; i++ {
+		p := PaymentRequest{
+			CorrelationID: "p",
+			Amount:        Money(rng.Intn(100000)),
+			RequestedAt:   base.Add(time.Duration(rng.Intn(180000)) * time.Millisecond),
+			Fallback:      rng.Intn(4) == 0,
+		}
+		payments = append(payments, p)
+		idx.Add(p)
+	}
+
+	check := func(from, to time.Time, useDateFilter bool, intervalMs int64) {
+		t.Helper()
+		want := scanSeries(payments, from, to, useDateFilter, intervalMs)
+		got := idx.Series(from, to, useDateFilter, intervalMs)
+		if len(got) != len(want) {
+			t.Fatalf("series(%s, %s, %v, %dms): %d pontos, want %d",
+				formatDate(from), formatDate(to), useDateFilter, intervalMs, len(got), len(want))
+		}
+		for i, p := range got {
+			if i > 0 && got[i-1].StartMs >= p.StartMs {
+				t.Fatalf("series(%dms) fora de ordem em %d", intervalMs, i)
+			}
+			if p.Totals != want[p.StartMs] {
+				t.Fatalf("series(%s, %s, %v, %dms) em %d = %+v, want %+v",
+					formatDate(from), formatDate(to), useDateFilter, intervalMs, p.StartMs, p.Totals, want[p.StartMs])
+			}
+		}
+	}
+
+	// Intervalos múltiplos de um segundo usam os totais do segundo; os outros descem aos milissegundos.
+	for _, intervalMs := range []int64{1, 7, 250, 1000, 1500, 15000, 60000} {
+		check(time.Time{}, time.Time{}, false, intervalMs)
+		check(base, base.Add(3*time.Minute), true, intervalMs)
+		for i := 0; i < 200; i++ {
+			from := base.Add(time.Duration(rng.Intn(190000)-5000) * time.Millisecond)
+			to := from.Add(time.Duration(rng.Intn(30000)) * time.Millisecond)
+			check(from, to, true, intervalMs)
+			// Bordas fora do grid de milissegundos.
+			check(from.Add(300*time.Microsecond), to.Add(700*time.Microsecond), true, intervalMs)
+		}
+	}
+}
+
+func TestSeriesIntervalsBoundedByDataSpan(t *testing.T) {
+	saved := summaryIndex
+	defer func() { summaryIndex = saved }()
+	summaryIndex = newSummaryIndex()
+
+	q := summaryQuery{groupBy: time.Millisecond}
+	if n := seriesIntervals(q); n != 0 {
+		t.Fatalf("índice vazio: %d intervalos, want 0", n)
+	}
+
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	summaryIndex.Add(PaymentRequest{RequestedAt: base.Add(500 * time.Millisecond), Amount: 100})
+	summaryIndex.Add(PaymentRequest{RequestedAt: base.Add(time.Hour), Amount: 100})
+
+	// Sem janela a conta vai do primeiro ao último segundo com pagamentos.
+	if n, want := seriesIntervals(q), int64(time.Hour/time.Millisecond)+1000; n != want {
+		t.Fatalf("sem janela: %d intervalos, want %d", n, want)
+	}
+	// A janela é recortada ao trecho com pagamentos.
+	q = summaryQuery{from: time.Unix(0, 0).UTC(), to: base.Add(10 * time.Second), useDateFilter: true, groupBy: time.Second}
+	if n := seriesIntervals(q); n != 11 {
+		t.Fatalf("janela recortada: %d intervalos, want 11", n)
+	}
+	q.from, q.to = base.Add(2*time.Hour), base.Add(3*time.Hour)
+	if n := seriesIntervals(q); n != 0 {
+		t.Fatalf("janela sem pagamentos: %d intervalos, want 0", n)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formato do WAL (tudo little-endian), pensado para ser lido por ferramentas externas:
+//
+//	segmento: magic "RWAL" | versão do segmento (uint32) | registros...
+//	registro: tamanho do payload (uint32) | crc32c do payload (uint32) | payload
+//	payload:  versão (uint8) | flags (uint8, bit 0 = fallback) | requestedAt em unix nanos (int64) |
+//	          amount em centavos (int64) | tamanho do correlationId (uint16) | correlationId |
+//	          tamanho do nome do processador (uint8) | nome do processador
+//
+// Os segmentos se chamam <sequência com 20 dígitos>.wal e são rotacionados ao passar de WAL_SEGMENT_BYTES.
+const (
+	walMagic         = "RWAL"
+	walVersion       = 1
+	walHeaderSize    = 8
+	walRecordHeader  = 8
+	walRecordVersion = 1
+	walMaxRecordSize = 1 << 16
+	// walMinPayloadSize é o payload com correlationId e processador vazios.
+	walMinPayloadSize = 21
+	walSegmentSuffix  = ".wal"
+)
+
+var (
+	walCRCTable   = crc32.MakeTable(crc32.Castagnoli)
+	errWALCorrupt = errors.New("registro do WAL corrompido")
+
+	paymentLog *WAL
+)
+
+type WALPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+type WAL struct {
+	dir         string
+	segmentSize int64
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	segment uint64
+	offset  int64
+	dirty   bool
+	// retired são segmentos já rotacionados que ainda esperam o fsync e o close de Sync.
+	retired []*os.File
+
+	// syncMu serializa os fsyncs, que rodam fora de mu: enquanto o disco sincroniza, Append
+	// (chamado pelo saver com storageMutex) continua escrevendo no buffer.
+	syncMu sync.Mutex
+}
+
+func initWAL() {
+	dir := getenvString("WAL_DIR", "data/wal")
+	w, err := openWAL(dir, getenvInt64("WAL_SEGMENT_BYTES", 8<<20))
+	if err != nil {
+		log.Fatalf("Erro ao abrir WAL: %v", err)
+	}
+
+	snapshots := &snapshotter{
+		dir:    getenvString("SNAPSHOT_DIR", "data/snapshots"),
+		retain: max(getenvInt("SNAPSHOT_RETAIN", 2), 1),
+	}
+	if err := os.MkdirAll(snapshots.dir, 0o755); err != nil {
+		log.Fatalf("Erro ao criar diretório de snapshots: %v", err)
+	}
+
+	from, payments, ok := snapshots.loadLatest()
+	if ok {
+		storage = payments
+		fmt.Printf("[%s][SNAPSHOT] Loaded %d payments covering WAL %d:%d\n",
+			getUTCNowFormatted(), len(payments), from.Segment, from.Offset)
+	}
+
+	replayed := 0
+	err = w.Recover(from, func(p PaymentRequest) {
+		storage = append(storage, p)
+		replayed++
+	})
+	if err != nil {
+		log.Fatalf("Erro ao recuperar WAL: %v", err)
+	}
+	fmt.Printf("[%s][WAL] Replayed %d payments from %s\n", getUTCNowFormatted(), replayed, dir)
+
+	paymentLog = w
+	go w.syncLoop(getenvDurationMS("WAL_FSYNC_INTERVAL_MS", 20))
+
+	if interval := getenvDurationSec("SNAPSHOT_INTERVAL_SEC", 30); interval > 0 {
+		go snapshots.loop(interval)
+	}
+}
+
+func openWAL(dir string, segmentSize int64) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &WAL{dir: dir, segmentSize: segmentSize}, nil
+}
+
+// Recover relê os registros a partir de from e deixa o último segmento aberto para escrita.
+// Um final truncado/corrompido no último segmento é descartado (escrita interrompida por crash).
+func (w *WAL) Recover(from WALPosition, fn func(PaymentRequest)) error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	var last uint64
+	var lastEnd int64
+	for i, seq := range segments {
+		if seq < from.Segment {
+			continue
+		}
+		start := int64(0)
+		if seq == from.Segment {
+			start = from.Offset
+		}
+
+		end, err := readSegment(w.segmentPath(seq), start, fn)
+		if err != nil {
+			if !errors.Is(err, errWALCorrupt) {
+				return err
+			}
+			fmt.Printf("[%s][WAL] Segment %d corrupted at offset %d: %v\n", getUTCNowFormatted(), seq, end, err)
+			if i != len(segments)-1 {
+				continue
+			}
+		}
+		last, lastEnd = seq, end
+	}
+
+	if last == 0 {
+		last = max(from.Segment, 1)
+		if len(segments) > 0 {
+			last = max(last, segments[len(segments)-1])
+		}
+		lastEnd = 0
+	}
+	return w.openSegment(last, lastEnd)
+}
+
+func (w *WAL) Append(p PaymentRequest) error {
+	payload := encodeWALPayload(p)
+
+	var header [walRecordHeader]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, walCRCTable))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.writer.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(payload); err != nil {
+		return err
+	}
+	w.offset += int64(len(header) + len(payload))
+	w.dirty = true
+
+	if w.offset >= w.segmentSize {
+		return w.rotate()
+	}
+	return nil
+}
+
+// Position devolve o ponto do log logo após o último registro gravado.
+func (w *WAL) Position() WALPosition {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WALPosition{Segment: w.segment, Offset: w.offset}
+}
+
+// Sync grava o buffer e faz o fsync do que foi gravado. Só o Flush precisa de mu: o fsync
+// depois dele cobre tudo que o Flush escreveu, mesmo que novos registros entrem no meio.
+func (w *WAL) Sync() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	w.mu.Lock()
+	file, retired, err := w.flushLocked()
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for i, f := range retired {
+		if err := f.Sync(); err != nil {
+			w.mu.Lock()
+			w.retired = append(retired[i:], w.retired...)
+			w.dirty = w.dirty || file != nil
+			w.mu.Unlock()
+			return err
+		}
+		f.Close()
+	}
+	if file == nil {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		w.mu.Lock()
+		w.dirty = true
+		w.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (w *WAL) Close() error {
+	if err := w.Sync(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// RemoveSegmentsBefore apaga os segmentos anteriores a seq, nunca o segmento ativo.
+func (w *WAL) RemoveSegmentsBefore(seq uint64) error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	active := w.segment
+	w.mu.Unlock()
+
+	for _, s := range segments {
+		if s >= seq || s >= active {
+			break
+		}
+		if err := os.Remove(w.segmentPath(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := w.Sync(); err != nil {
+			fmt.Printf("Erro ao sincronizar WAL: %v\n", err)
+		}
+	}
+}
+
+// flushLocked esvazia o buffer e devolve o que Sync precisa sincronizar: o arquivo ativo, se
+// havia algo novo nele, e os segmentos rotacionados desde o último Sync.
+func (w *WAL) flushLocked() (*os.File, []*os.File, error) {
+	retired := w.retired
+	w.retired = nil
+	if !w.dirty {
+		return nil, retired, nil
+	}
+	if err := w.writer.Flush(); err != nil {
+		w.retired = retired
+		return nil, nil, err
+	}
+	w.dirty = false
+	return w.file, retired, nil
+}
+
+// rotate troca para o próximo segmento; o anterior fica em retired até o próximo Sync.
+func (w *WAL) rotate() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	w.retired = append(w.retired, w.file)
+	return w.openSegment(w.segment+1, 0)
+}
+
+// openSegment abre o segmento seq para escrita, truncando-o em end (0 recria o cabeçalho).
+func (w *WAL) openSegment(seq uint64, end int64) error {
+	f, err := os.OpenFile(w.segmentPath(seq), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if end < walHeaderSize {
+		end = 0
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.writer = bufio.NewWriterSize(f, 64<<10)
+	w.segment = seq
+	w.offset = end
+
+	if end == 0 {
+		var header [walHeaderSize]byte
+		copy(header[0:4], walMagic)
+		binary.LittleEndian.PutUint32(header[4:8], walVersion)
+		if _, err := w.writer.Write(header[:]); err != nil {
+			return err
+		}
+		w.offset = walHeaderSize
+		w.dirty = true
+	}
+	return nil
+}
+
+func (w *WAL) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, walSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, seq)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+func (w *WAL) segmentPath(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walSegmentSuffix))
+}
+
+// readSegment chama fn para cada registro válido a partir de start e devolve o offset
+// logo após o último registro íntegro.
+func readSegment(path string, start int64, fn func(PaymentRequest)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64<<10)
+
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, errWALCorrupt
+	}
+	if string(header[0:4]) != walMagic || binary.LittleEndian.Uint32(header[4:8]) != walVersion {
+		return 0, errWALCorrupt
+	}
+
+	offset := int64(walHeaderSize)
+	if start > offset {
+		if _, err := r.Discard(int(start - offset)); err != nil {
+			return offset, errWALCorrupt
+		}
+		offset = start
+	}
+
+	var recHeader [walRecordHeader]byte
+	payload := make([]byte, 0, 256)
+	for {
+		if _, err := io.ReadFull(r, recHeader[:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, errWALCorrupt
+		}
+
+		size := binary.LittleEndian.Uint32(recHeader[0:4])
+		if size == 0 || size > walMaxRecordSize {
+			return offset, errWALCorrupt
+		}
+		if int(size) > cap(payload) {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, errWALCorrupt
+		}
+		if crc32.Checksum(payload, walCRCTable) != binary.LittleEndian.Uint32(recHeader[4:8]) {
+			return offset, errWALCorrupt
+		}
+
+		p, err := decodeWALPayload(payload)
+		if err != nil {
+			return offset, err
+		}
+		fn(p)
+		offset += int64(walRecordHeader) + int64(size)
+	}
+}
+
+func encodeWALPayload(p PaymentRequest) []byte {
+	id := p.CorrelationID
+	if len(id) > math.MaxUint16 {
+		id = id[:math.MaxUint16]
+	}
+	processor := p.Processor
+	if len(processor) > math.MaxUint8 {
+		processor = processor[:math.MaxUint8]
+	}
+
+	buf := make([]byte, 0, 21+len(id)+len(processor))
+	buf = append(buf, walRecordVersion)
+	var flags byte
+	if p.Fallback {
+		flags |= 1
+	}
+	buf = append(buf, flags)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(p.RequestedAt.UnixNano()))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(p.Amount))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(id)))
+	buf = append(buf, id...)
+	buf = append(buf, byte(len(processor)))
+	buf = append(buf, processor...)
+	return buf
+}
+
+func decodeWALPayload(buf []byte) (PaymentRequest, error) {
+	if len(buf) < walMinPayloadSize || buf[0] != walRecordVersion {
+		return PaymentRequest{}, errWALCorrupt
+	}
+	idEnd := 20 + int(binary.LittleEndian.Uint16(buf[18:20]))
+	if len(buf) < idEnd+1 || len(buf) != idEnd+1+int(buf[idEnd]) {
+		return PaymentRequest{}, errWALCorrupt
+	}
+
+	p := PaymentRequest{
+		CorrelationID: string(buf[20:idEnd]),
+		Amount:        Money(binary.LittleEndian.Uint64(buf[10:18])),
+		RequestedAt:   time.Unix(0, int64(binary.LittleEndian.Uint64(buf[2:10]))).UTC(),
+		Fallback:      buf[1]&1 != 0,
+	}
+	p.Processor = string(buf[idEnd+1:])
+	return p, nil
+}
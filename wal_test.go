@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func walPayments(n int) []PaymentRequest {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	out := make([]PaymentRequest, n)
+	for i := range out {
+		out[i] = PaymentRequest{
+			CorrelationID: fmt.Sprintf("p-%04d", i),
+			Amount:        Money(1990 + i),
+			RequestedAt:   base.Add(time.Duration(i) * time.Millisecond),
+			Processor:     "default",
+		}
+		if i%3 == 0 {
+			out[i].Processor, out[i].Fallback = "fallback", true
+		}
+	}
+	return out
+}
+
+func recoverAll(t *testing.T, dir string, from WALPosition) (*WAL, []PaymentRequest) {
+	t.Helper()
+	w, err := openWAL(dir, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []PaymentRequest
+	if err := w.Recover(from, func(p PaymentRequest) { got = append(got, p) }); err != nil {
+		t.Fatal(err)
+	}
+	return w, got
+}
+
+func checkPayments(t *testing.T, got, want []PaymentRequest) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d pagamentos recuperados, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pagamento %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWALRecoverAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	payments := walPayments(200)
+
+	w, _ := recoverAll(t, dir, WALPosition{})
+	var mid WALPosition
+	for i, p := range payments {
+		if err := w.Append(p); err != nil {
+			t.Fatal(err)
+		}
+		if i == 119 {
+			mid = w.Position()
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := w.segments(); len(segments) < 3 {
+		t.Fatalf("%d segmentos, want rotação com segmentos de 1KiB", len(segments))
+	}
+
+	w, got := recoverAll(t, dir, WALPosition{})
+	w.Close()
+	checkPayments(t, got, payments)
+
+	// A partir de uma posição, como depois de carregar um snapshot, só vem o resto do log.
+	w, got = recoverAll(t, dir, mid)
+	w.Close()
+	checkPayments(t, got, payments[120:])
+}
+
+func TestWALSyncConcurrentWithAppend(t *testing.T) {
+	dir := t.TempDir()
+	payments := walPayments(500)
+
+	w, _ := recoverAll(t, dir, WALPosition{})
+	done := make(chan struct{})
+	synced := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-done:
+				synced <- w.Sync()
+				return
+			default:
+				if err := w.Sync(); err != nil {
+					synced <- err
+					return
+				}
+			}
+		}
+	}()
+	for _, p := range payments {
+		if err := w.Append(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+	if len(w.retired) != 0 {
+		t.Fatalf("%d segmentos rotacionados sem fsync", len(w.retired))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, got := recoverAll(t, dir, WALPosition{})
+	w.Close()
+	checkPayments(t, got, payments)
+}
+
+func TestWALRecoverDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	payments := walPayments(10)
+
+	w, _ := recoverAll(t, dir, WALPosition{})
+	for _, p := range payments {
+		if err := w.Append(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end := w.Position()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simula um crash no meio da escrita do último registro.
+	path := w.segmentPath(end.Segment)
+	if err := os.Truncate(path, end.Offset-3); err != nil {
+		t.Fatal(err)
+	}
+
+	w, got := recoverAll(t, dir, WALPosition{})
+	checkPayments(t, got, payments[:9])
+
+	// O final descartado é sobrescrito pelos próximos registros.
+	extra := walPayments(12)[11]
+	if err := w.Append(extra); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w, got = recoverAll(t, dir, WALPosition{})
+	w.Close()
+	checkPayments(t, got, append(payments[:9:9], extra))
+}
+
+func TestWALRecoverSkipsCorruptOlderSegment(t *testing.T) {
+	dir := t.TempDir()
+	payments := walPayments(100)
+
+	w, _ := recoverAll(t, dir, WALPosition{})
+	for _, p := range payments {
+		if err := w.Append(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	// Corrompe o CRC do primeiro registro do primeiro segmento: o segmento é abandonado
+	// ali, mas os seguintes continuam sendo lidos.
+	path := w.segmentPath(1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[walHeaderSize+4] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, got := recoverAll(t, dir, WALPosition{})
+	w.Close()
+	if len(got) == 0 || len(got) >= len(payments) {
+		t.Fatalf("%d pagamentos recuperados, want só os dos segmentos íntegros", len(got))
+	}
+	checkPayments(t, got, payments[len(payments)-len(got):])
+}
+
+func TestDecodeWALPayloadRejectsMalformed(t *testing.T) {
+	valid := encodeWALPayload(walPayments(1)[0])
+	if _, err := decodeWALPayload(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	otherVersion := append([]byte{walRecordVersion + 1}, valid[1:]...)
+	for _, bad := range [][]byte{nil, valid[:20], valid[:len(valid)-1], append(valid, 'x'), otherVersion} {
+		if _, err := decodeWALPayload(bad); err == nil {
+			t.Errorf("payload %x aceito", bad)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// persistWatermark acompanha os pagamentos que já receberam RequestedAt mas ainda não
+// chegaram ao summaryIndex. A marca d'água é o maior instante W tal que todo pagamento com
+// RequestedAt <= W já está salvo neste nó: o instante logo antes do pagamento pendente mais
+// antigo ou, sem pendentes, logo antes do milissegundo atual. Como RequestedAt é atribuído
+// sob o mesmo lock, nenhum pagamento novo recebe um instante abaixo da marca já publicada.
+var persistWatermark = newWatermark()
+
+type Watermark struct {
+	mu      sync.Mutex
+	pending map[int64]int
+}
+
+func newWatermark() *Watermark {
+	return &Watermark{pending: make(map[int64]int)}
+}
+
+// Begin devolve o RequestedAt de um envio e o marca como pendente.
+func (w *Watermark) Begin() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	w.pending[at.UnixNano()]++
+	return at
+}
+
+// Done tira um pagamento de pendente: ele foi salvo ou o envio falhou e um novo Begin
+// vai dar outro RequestedAt.
+func (w *Watermark) Done(at time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := at.UnixNano()
+	if w.pending[key] <= 1 {
+		delete(w.pending, key)
+		return
+	}
+	w.pending[key]--
+}
+
+func (w *Watermark) Current() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	oldest := time.Now().UTC().Truncate(time.Millisecond).UnixNano()
+	for at := range w.pending {
+		oldest = min(oldest, at)
+	}
+	return time.Unix(0, oldest-1).UTC()
+}
+
+// WaitFor espera até a marca d'água alcançar t ou o prazo acabar e devolve a marca final.
+func (w *Watermark) WaitFor(t time.Time, wait time.Duration) (time.Time, bool) {
+	deadline := time.Now().Add(wait)
+	for {
+		mark := w.Current()
+		if !mark.Before(t) {
+			return mark, true
+		}
+		if !time.Now().Before(deadline) {
+			return mark, false
+		}
+		time.Sleep(min(5*time.Millisecond, time.Until(deadline)))
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"sync/atomic"
 	"time"
@@ -13,78 +15,155 @@ const (
 )
 
 var (
-	queue        = make(chan *PaymentRequest, QueueCapacity)
-	workerClient = &http.Client{Timeout: getenvDurationMS("MAX_DEFAULT_LATENCY", 100) * 2 * time.Millisecond}
+	queue = make(chan *PaymentRequest, QueueCapacity)
+
+	retryMaxAttempts = getenvInt("RETRY_MAX_ATTEMPTS", 16)
+	retryBaseDelay   = getenvDurationMS("RETRY_BASE_DELAY_MS", 100)
+	retryMaxDelay    = getenvDurationMS("RETRY_MAX_DELAY_MS", 5000)
 )
 
+// worker processa a fila até stopWorkers ser fechado. Ele só sai entre um pagamento e
+// outro, então quando workersDone zera nenhum worker segura um pagamento fora da fila e
+// do scheduler.
 func worker() {
-	for p := range queue {
-		status := atomic.LoadInt32(&circuitStatusFlag)
+	defer workersDone.Done()
+	for {
+		select {
+		case <-stopWorkers:
+			return
+		case p := <-queue:
+			workersBusy.Add(1)
+			processPayment(p)
+			workersBusy.Add(-1)
+		}
+	}
+}
+
+func processPayment(p *PaymentRequest) {
+	status, probe := breaker.Route(RouteDecision(atomic.LoadInt32(&circuitStatusFlag)), time.Now())
 
-		if status == 2 {
-			queue <- p
-			time.Sleep(500 * time.Millisecond)
-			continue
+	// Durante o shutdown (ou com o circuito aberto) o pagamento fica retido no scheduler.
+	if status == RouteHold || workersStopped.Load() {
+		if probe {
+			breaker.CancelProbe()
 		}
+		retryScheduler.Park(p)
+		return
+	}
 
-		success := tryProcessPayment(p, status)
-		if !success && status == 0 {
-			fallbackSuccess := tryProcessPayment(p, 1)
-			if fallbackSuccess {
-				p.Fallback = true
-				saveChan <- *p
-			} else {
-				queue <- p
+	tracker.Start(p.CorrelationID)
+	err := tryProcessPayment(p, status, probe)
+	if probe {
+		breaker.ProbeResult(err == nil, time.Now())
+		retryScheduler.CircuitChanged()
+	}
+	// Sondas não caem para outro processador: o controlador decidiu segurar os pagamentos.
+	if err != nil && !probe {
+		for _, fallback := range currentCircuitState().Fallbacks {
+			if fallback == status {
+				continue
+			}
+			tracker.Start(p.CorrelationID)
+			if err = tryProcessPayment(p, fallback, false); err == nil {
+				break
 			}
-		} else if success {
-			saveChan <- *p
-		} else {
-			queue <- p
 		}
 	}
+	if err == nil {
+		saveChan <- *p
+	} else {
+		retryPayment(p, err)
+	}
+}
+
+// retryPayment devolve o pagamento à fila após um backoff exponencial com jitter ou,
+// esgotado o orçamento de tentativas, move-o para a dead-letter queue.
+func retryPayment(p *PaymentRequest, err error) {
+	p.attempts++
+	if p.attempts >= retryMaxAttempts {
+		tracker.Fail(p.CorrelationID, err)
+		deadLetters.Add(p, err)
+		fmt.Printf("[%s][DEAD-LETTER] Payment %s failed after %d attempts: %v\n",
+			getUTCNowFormatted(), p.CorrelationID, p.attempts, err)
+		return
+	}
+
+	tracker.Retry(p.CorrelationID, err)
+	retryScheduler.Schedule(p, time.Now().Add(retryBackoff(p.attempts)))
+}
+
+func retryBackoff(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 32 {
+		delay = min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	}
+	// Equal jitter: metade fixa, metade aleatória, para espalhar as retentativas.
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
 }
 
-func tryProcessPayment(p *PaymentRequest, circuitStatus int32) bool {
-	targetURL := primaryURL
-	if circuitStatus == 1 {
-		targetURL = fallbackURL
+func tryProcessPayment(p *PaymentRequest, route RouteDecision, probe bool) (err error) {
+	processor := processorFor(route)
+	if processor == nil {
+		return fmt.Errorf("rota inválida: %d", route)
 	}
 
-	p.RequestedAt = time.Now().UTC().Truncate(time.Millisecond)
+	p.RequestedAt = persistWatermark.Begin()
+	defer func() {
+		// Com sucesso o pagamento segue pendente até o saver; com falha a próxima tentativa recebe outro RequestedAt.
+		if err != nil {
+			persistWatermark.Done(p.RequestedAt)
+		}
+	}()
 	marshaled, _ := json.Marshal(p)
-	req, _ := http.NewRequest("POST", targetURL+"/payments", bytes.NewBuffer(marshaled))
+	req, _ := http.NewRequest("POST", processor.URL+"/payments", bytes.NewBuffer(marshaled))
 	req.Header.Set("Content-Type", "application/json")
 
 	start := time.Now()
-	resp, err := workerClient.Do(req)
+	resp, err := processor.client.Do(req)
 	duration := time.Since(start).Milliseconds()
+	failed := err != nil || (resp != nil && resp.StatusCode != 200)
 
-	if isMaster {
-		metricsChan <- Metric{
-			Primary:    circuitStatus == 0,
-			DurationMs: duration,
-			Failed:     err != nil || (resp != nil && resp.StatusCode != 200),
-		}
-	}
+	processor.latency.Record(duration, failed)
+	reportMetric(Metric{
+		Processor:  processor.Index,
+		Probe:      probe,
+		DurationMs: duration,
+		Failed:     failed,
+	})
 
 	if err != nil {
-		return false
+		return err
 	}
 
 	if resp != nil {
 		defer resp.Body.Close()
 		if resp.StatusCode != 200 {
-			return false
+			return fmt.Errorf("%s respondeu status %d", processor.Name, resp.StatusCode)
 		}
 	}
 
-	return true
+	p.Processor = processor.Name
+	p.Fallback = processor.Index != 0
+	return nil
 }
 
 func startInMemorySaver() {
 	for msg := range saveChan {
+		// WAL e storage andam juntos sob o mesmo lock para que um snapshot
+		// sempre cubra exatamente a posição do log que ele registra.
 		storageMutex.Lock()
+		if paymentLog != nil {
+			if err := paymentLog.Append(msg); err != nil {
+				fmt.Printf("Erro ao gravar pagamento no WAL: %v\n", err)
+			}
+		}
 		storage = append(storage, msg)
 		storageMutex.Unlock()
+
+		summaryIndex.Add(msg)
+		persistWatermark.Done(msg.RequestedAt)
+		tracker.Processed(msg)
 	}
+	close(saverDone)
 }